@@ -0,0 +1,320 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Audit event names.
+const (
+	AuditTokenIssued       = "token.issued"
+	AuditPrivilegedRequest = "request.privileged"
+)
+
+// Audit decisions for privileged requests.
+const (
+	AuditAllowed = "allowed"
+	AuditDenied  = "denied"
+)
+
+// AuditForbidden is the reason recorded when a request's token was
+// accepted, but an authorization check such as Require then denied it.
+const AuditForbidden = "forbidden"
+
+// ErrAuditChannelFull is returned by a ChannelAuditSink whose buffer is full.
+var ErrAuditChannelFull = errors.New("audit channel is full")
+
+// AuditEvent records a token being issued, or a privileged request being
+// authenticated.  Privileged requests are those made with an admin user's
+// token or an internal-account token.
+type AuditEvent struct {
+	Time      time.Time  `json:"time"`
+	Event     string     `json:"event"`
+	TokenID   string     `json:"jti,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	Principal string     `json:"principal,omitempty"`
+	OrgID     string     `json:"orgID,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// These are set for requests only.
+	CallerIP string `json:"callerIP,omitempty"`
+	Method   string `json:"method,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Decision string `json:"decision,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// AuditSink receives audit events.  If it returns an error, the token is
+// not issued, or the request is failed, so that nothing goes unrecorded.
+type AuditSink interface {
+	Audit(event AuditEvent) error
+}
+
+// WithSignerAuditSink records every token issued by the Signer.
+func WithSignerAuditSink(sink AuditSink) SignerOption {
+	return func(s *Signer) {
+		s.auditSink = sink
+	}
+}
+
+// WithVerifierAuditSink records every privileged request seen by the
+// Verifier's middleware.  A request which is accepted by the middleware is
+// recorded once the handlers behind it have decided whether to allow it,
+// before its response is written, so denials by Require and RequireTenant
+// are recorded too.
+func WithVerifierAuditSink(sink AuditSink) VerifierOption {
+	return func(v *Verifier) {
+		v.auditSink = sink
+	}
+}
+
+// newAuditEvent fills in the fields describing the token.
+func newAuditEvent(event string, claims *SsdJwtClaims, now time.Time) AuditEvent {
+	e := AuditEvent{
+		Time:    now.UTC(),
+		Event:   event,
+		TokenID: claims.ID,
+		Type:    claims.SSDCLaims.Type,
+		OrgID:   claims.SSDCLaims.OrgID,
+	}
+	if claims.ExpiresAt != nil {
+		expiry := claims.ExpiresAt.Time.UTC()
+		e.ExpiresAt = &expiry
+	}
+	if id, err := IdentityFromClaims(claims); err == nil {
+		e.Principal = id.Principal()
+	}
+	return e
+}
+
+func (s *Signer) auditIssued(claims *SsdJwtClaims) error {
+	if s.auditSink == nil {
+		return nil
+	}
+	return s.auditSink.Audit(newAuditEvent(AuditTokenIssued, claims, s.now()))
+}
+
+// isPrivileged returns true for admin users and internal accounts.
+func isPrivileged(claims *SsdJwtClaims) bool {
+	if claims.SSDCLaims.IsAdmin {
+		return true
+	}
+	return sameTokenType(claims.SSDCLaims.Type, SSDTokenTypeInternal)
+}
+
+// auditRequest records a privileged request, which was denied if
+// reason is set.
+func (v *Verifier) auditRequest(r *http.Request, claims *SsdJwtClaims, reason RejectReason) error {
+	if v.auditSink == nil || !isPrivileged(claims) {
+		return nil
+	}
+	return v.auditSink.Audit(v.requestAuditEvent(r, claims, string(reason)))
+}
+
+func (v *Verifier) requestAuditEvent(r *http.Request, claims *SsdJwtClaims, reason string) AuditEvent {
+	e := newAuditEvent(AuditPrivilegedRequest, claims, v.now())
+	e.CallerIP = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		e.CallerIP = host
+	}
+	e.Method = r.Method
+	e.Path = r.URL.Path
+	e.Decision = AuditAllowed
+	if reason != "" {
+		e.Decision = AuditDenied
+		e.Reason = reason
+	}
+	return e
+}
+
+// newPendingAudit returns the audit record for a privileged request which
+// the middleware accepted, or nil if it is not to be audited.
+func (v *Verifier) newPendingAudit(r *http.Request, claims *SsdJwtClaims) *pendingAudit {
+	if v.auditSink == nil || !isPrivileged(claims) {
+		return nil
+	}
+	return &pendingAudit{sink: v.auditSink, event: v.requestAuditEvent(r, claims, "")}
+}
+
+// pendingAudit holds the event for an accepted privileged request until
+// the handlers have decided whether to allow it.
+type pendingAudit struct {
+	mu       sync.Mutex
+	sink     AuditSink
+	event    AuditEvent
+	recorded bool
+	err      error
+}
+
+// denyAudit marks the request's pending audit event, if any, as denied.
+func denyAudit(ctx context.Context, reason string) {
+	if p, ok := ctx.Value(ssdAuditContextKey).(*pendingAudit); ok {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.event.Decision = AuditDenied
+		p.event.Reason = reason
+	}
+}
+
+// record sends the event to the sink, the first time it is called.
+func (p *pendingAudit) record() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.recorded {
+		p.recorded = true
+		p.err = p.sink.Audit(p.event)
+	}
+	return p.err
+}
+
+var errAuditFailed = errors.New("unable to audit request")
+
+// auditResponseWriter records the pending event before the response is
+// started, so that if it cannot be recorded the request can still fail.
+type auditResponseWriter struct {
+	http.ResponseWriter
+	audit   *pendingAudit
+	log     *slog.Logger
+	started bool
+	failed  bool
+}
+
+// start records the event, and reports whether the handler's response
+// may be written.
+func (w *auditResponseWriter) start() bool {
+	if w.started {
+		return !w.failed
+	}
+	w.started = true
+	if err := w.audit.record(); err != nil {
+		w.log.Error("unable to audit request", "error", err)
+		w.failed = true
+		w.ResponseWriter.WriteHeader(http.StatusInternalServerError)
+		w.ResponseWriter.Write([]byte("Internal Server Error"))
+	}
+	return !w.failed
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if w.start() {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	if !w.start() {
+		return 0, errAuditFailed
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying writer.
+func (w *auditResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush records the event first, so streaming handlers, such as those
+// sending server-sent events, work for audited requests.
+func (w *auditResponseWriter) Flush() {
+	if !w.start() {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack records the event first, so handlers which take over the
+// connection, such as websocket upgrades, work for audited requests.
+func (w *auditResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if !w.start() {
+		return nil, nil, errAuditFailed
+	}
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+// JSONLinesAuditSink writes each event as a line of JSON.
+type JSONLinesAuditSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewJSONLinesAuditSink writes events to w.
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{w: w, enc: json.NewEncoder(w)}
+}
+
+// OpenJSONLinesAuditFile appends events to the named file, creating it
+// if needed.  Close the sink to close the file.
+func OpenJSONLinesAuditFile(filename string) (*JSONLinesAuditSink, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesAuditSink(f), nil
+}
+
+func (s *JSONLinesAuditSink) Audit(event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(event)
+}
+
+// Close closes the underlying writer, if it is an io.Closer.
+func (s *JSONLinesAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ChannelAuditSink sends events on C.  Audit never blocks: if the consumer
+// has fallen behind and the buffer is full, it returns ErrAuditChannelFull,
+// so the token is not issued or the request is failed.
+type ChannelAuditSink struct {
+	C chan AuditEvent
+}
+
+// NewChannelAuditSink returns a ChannelAuditSink buffering size events.
+func NewChannelAuditSink(size int) *ChannelAuditSink {
+	return &ChannelAuditSink{C: make(chan AuditEvent, size)}
+}
+
+func (s *ChannelAuditSink) Audit(event AuditEvent) error {
+	select {
+	case s.C <- event:
+		return nil
+	default:
+		return ErrAuditChannelFull
+	}
+}
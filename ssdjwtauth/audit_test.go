@@ -0,0 +1,327 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) Audit(AuditEvent) error {
+	return errors.New("disk full")
+}
+
+func TestSignerAudit(t *testing.T) {
+	s, _ := newTestSignerVerifier(t)
+	var buf bytes.Buffer
+	WithSignerAuditSink(NewJSONLinesAuditSink(&buf))(s)
+
+	now := time.Now()
+	if _, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "jti-1", testUserClaims)); err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	var e AuditEvent
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("decoding audit event %q: %v", buf.String(), err)
+	}
+	want := AuditEvent{
+		Time:      e.Time,
+		Event:     AuditTokenIssued,
+		TokenID:   "jti-1",
+		Type:      SSDTokenTypeUser,
+		Principal: "alice",
+		OrgID:     "org1",
+	}
+	if e.ExpiresAt == nil || !e.ExpiresAt.Equal(now.Add(time.Hour).Truncate(time.Second)) {
+		t.Errorf("expiresAt = %v, want %v", e.ExpiresAt, now.Add(time.Hour))
+	}
+	e.ExpiresAt = nil
+	if e != want {
+		t.Errorf("event = %+v, want %+v", e, want)
+	}
+
+	WithSignerAuditSink(failingAuditSink{})(s)
+	if _, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "jti-2", testUserClaims)); err == nil {
+		t.Errorf("expected SignToken to fail when the audit sink fails")
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		sink, err := OpenJSONLinesAuditFile(filename)
+		if err != nil {
+			t.Fatalf("OpenJSONLinesAuditFile: %v", err)
+		}
+		if err := sink.Audit(AuditEvent{Event: AuditTokenIssued}); err != nil {
+			t.Fatalf("Audit: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		var e AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Errorf("line %d: %v", lines, err)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d lines, want 2 appended lines", lines)
+	}
+}
+
+func TestMiddlewareAudit(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	sink := NewChannelAuditSink(10)
+	WithVerifierAuditSink(sink)(v)
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	admin := testUserClaims
+	admin.IsAdmin = true
+	internal := SSDClaims{Type: SSDTokenTypeInternal, Service: "scheduler"}
+	_, jwk := newDPoPKey(t)
+	now := time.Now()
+	bound := s.MakeClaims(now, now.Add(time.Hour), "bound", admin)
+	if err := bound.BindDPoPKey(jwk); err != nil {
+		t.Fatalf("BindDPoPKey: %v", err)
+	}
+	boundToken, err := s.SignToken(bound)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantCode  int
+		wantEvent *AuditEvent
+	}{
+		{"ordinary user", signTestToken(t, s, testUserClaims), http.StatusOK, nil},
+		{"invalid token", "garbage", http.StatusUnauthorized, nil},
+		{"admin", signTestToken(t, s, admin), http.StatusOK, &AuditEvent{Principal: "alice", Decision: AuditAllowed}},
+		{"internal", signTestToken(t, s, internal), http.StatusOK, &AuditEvent{Principal: "scheduler", Decision: AuditAllowed}},
+		{"admin without proof", boundToken, http.StatusUnauthorized, &AuditEvent{Principal: "alice", Decision: AuditDenied, Reason: string(RejectDPoP)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("DELETE", "/api/v1/things", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			select {
+			case e := <-sink.C:
+				if tt.wantEvent == nil {
+					t.Fatalf("unexpected audit event %+v", e)
+				}
+				if e.Event != AuditPrivilegedRequest || e.CallerIP != "192.0.2.1" || e.Method != "DELETE" || e.Path != "/api/v1/things" {
+					t.Errorf("event = %+v", e)
+				}
+				if e.Principal != tt.wantEvent.Principal || e.Decision != tt.wantEvent.Decision || e.Reason != tt.wantEvent.Reason {
+					t.Errorf("event = %+v, want %+v", e, tt.wantEvent)
+				}
+			default:
+				if tt.wantEvent != nil {
+					t.Errorf("no audit event")
+				}
+			}
+		})
+	}
+
+	WithVerifierAuditSink(failingAuditSink{})(v)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, s, admin))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status with failing audit sink = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestChannelAuditSink_full(t *testing.T) {
+	sink := NewChannelAuditSink(1)
+	if err := sink.Audit(AuditEvent{Event: AuditTokenIssued}); err != nil {
+		t.Fatalf("Audit: %v", err)
+	}
+	if err := sink.Audit(AuditEvent{Event: AuditTokenIssued}); !errors.Is(err, ErrAuditChannelFull) {
+		t.Errorf("Audit on a full channel: error = %v, want %v", err, ErrAuditChannelFull)
+	}
+}
+
+func TestMiddlewareAudit_finalDecision(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	sink := NewChannelAuditSink(10)
+	WithVerifierAuditSink(sink)(v)
+	WithReplayCache(NewMemoryReplayCache(0))(v)
+	WithVerifierLifetimePolicy(LifetimePolicy{Default: time.Hour})(v)
+
+	admin := testUserClaims
+	admin.IsAdmin = true
+	once := admin
+	once.Once = true
+	onceToken := signTestToken(t, s, once)
+	now := time.Now()
+	longLived, err := s.SignToken(s.MakeClaims(now, now.Add(2*time.Hour), "long", admin))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	silent := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	tests := []struct {
+		name      string
+		handler   http.Handler
+		token     string
+		wantCode  int
+		wantEvent AuditEvent
+	}{
+		{"allowed", ok, signTestToken(t, s, admin), http.StatusOK, AuditEvent{Decision: AuditAllowed}},
+		{"handler writes nothing", silent, signTestToken(t, s, admin), http.StatusOK, AuditEvent{Decision: AuditAllowed}},
+		{"denied by Require", RequireGroup("ops")(ok), signTestToken(t, s, admin), http.StatusForbidden, AuditEvent{Decision: AuditDenied, Reason: AuditForbidden}},
+		{"denied by token type", RequireTokenType(SSDTokenTypeService)(ok), signTestToken(t, s, admin), http.StatusForbidden, AuditEvent{Decision: AuditDenied, Reason: AuditForbidden}},
+		{"single use first", ok, onceToken, http.StatusOK, AuditEvent{Decision: AuditAllowed}},
+		{"single use replayed", ok, onceToken, http.StatusUnauthorized, AuditEvent{Decision: AuditDenied, Reason: string(RejectReplayed)}},
+		{"lifetime too long", ok, longLived, http.StatusUnauthorized, AuditEvent{Decision: AuditDenied, Reason: string(RejectClaims)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			v.MiddlewareFunc()(tt.handler).ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if len(sink.C) != 1 {
+				t.Fatalf("got %d audit events, want 1", len(sink.C))
+			}
+			e := <-sink.C
+			if e.Principal != "alice" || e.Decision != tt.wantEvent.Decision || e.Reason != tt.wantEvent.Reason {
+				t.Errorf("event = %+v, want %+v", e, tt.wantEvent)
+			}
+		})
+	}
+
+	WithVerifierAuditSink(failingAuditSink{})(v)
+	written := false
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("secret")); err == nil {
+			written = true
+		}
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, s, admin))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || written || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("failing audit sink: status = %d, body %q, want %d without the response", w.Code, w.Body.String(), http.StatusInternalServerError)
+	}
+}
+
+func TestMiddlewareAudit_flushAndHijack(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	sink := NewChannelAuditSink(10)
+	WithVerifierAuditSink(sink)(v)
+	admin := testUserClaims
+	admin.IsAdmin = true
+	token := signTestToken(t, s, admin)
+
+	t.Run("flush", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f, ok := w.(http.Flusher)
+			if !ok {
+				t.Fatalf("response writer of an audited request is not an http.Flusher")
+			}
+			w.Write([]byte("data: 1\n\n"))
+			f.Flush()
+		})
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		v.MiddlewareFunc()(handler).ServeHTTP(w, r)
+		if !w.Flushed {
+			t.Errorf("expected the response to be flushed")
+		}
+		if len(sink.C) != 1 {
+			t.Fatalf("got %d audit events, want 1", len(sink.C))
+		}
+		<-sink.C
+	})
+
+	t.Run("hijack", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h, ok := w.(http.Hijacker)
+			if !ok {
+				t.Errorf("response writer of an audited request is not an http.Hijacker")
+				return
+			}
+			conn, rw, err := h.Hijack()
+			if err != nil {
+				t.Errorf("Hijack: %v", err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			rw.Flush()
+		})
+		server := httptest.NewServer(v.MiddlewareFunc()(handler))
+		defer server.Close()
+		r, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "test")
+		resp, err := server.Client().Do(r)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+		if len(sink.C) != 1 {
+			t.Fatalf("got %d audit events, want 1", len(sink.C))
+		}
+		<-sink.C
+	})
+}
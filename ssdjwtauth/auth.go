@@ -16,6 +16,7 @@ package ssdjwtauth
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -44,6 +45,18 @@ var (
 type SsdJwtClaims struct {
 	jwt.RegisteredClaims
 	SSDCLaims SSDClaims `json:"ssd.opsmx.io"`
+	// Confirmation, if set, binds the token to a key held by the caller,
+	// who must prove possession of it with each request (RFC 7800).
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Confirmation is the "cnf" claim of a sender-constrained token.
+type Confirmation struct {
+	// JKT is the RFC 7638 thumbprint of the caller's DPoP key (RFC 9449).
+	JKT string `json:"jkt,omitempty"`
+	// X5TS256 is the SHA-256 thumbprint of the caller's mutual TLS client
+	// certificate (RFC 8705).
+	X5TS256 string `json:"x5t#S256,omitempty"`
 }
 
 // All possible claims.  For specific types of claims, some subset of these are used,
@@ -58,6 +71,17 @@ type SSDClaims struct {
 	Service        string   `json:"service,omitempty" yaml:"service,omitempty"`
 	Instance       string   `json:"instance,omitempty" yaml:"instance,omitempty"`
 	TeamID         string   `json:"teamID,omitempty" yaml:"teamID,omitempty"`
+	// GroupRef replaces Groups on the wire when the Signer has a GroupEncoder.
+	// Verifiers expand it back into Groups, so it is never set after verification.
+	GroupRef string `json:"groupRef,omitempty" yaml:"groupRef,omitempty"`
+	// Once marks a single-use token, which a Verifier with a ReplayCache
+	// accepts only the first time it is presented.
+	Once bool `json:"once,omitempty" yaml:"once,omitempty"`
+
+	// Custom holds the claims of a type registered with RegisterTokenType.
+	// When set, it is serialized as the ssd.opsmx.io claim in place of
+	// the fields above.
+	Custom any `json:"-" yaml:"-"`
 }
 
 type SSDUserClaims struct {
@@ -88,14 +112,11 @@ type SSDIntegrationClaims struct {
 }
 
 func SSDUserClaimsFromClaims(s *SsdJwtClaims) (*SSDUserClaims, error) {
-	if s.SSDCLaims.Type != SSDTokenTypeUser {
+	if !sameTokenType(s.SSDCLaims.Type, SSDTokenTypeUser) {
 		return nil, fmt.Errorf("cannot parse user claims from type %s", s.SSDCLaims.Type)
 	}
-	if s.SSDCLaims.UserID == "" {
-		return nil, fmt.Errorf("required field userID is not set in claims")
-	}
-	if s.SSDCLaims.OrgID == "" {
-		return nil, fmt.Errorf("required field orgID is not set in claims")
+	if err := ValidateSSDClaims(&s.SSDCLaims); err != nil {
+		return nil, err
 	}
 	groups := s.SSDCLaims.Groups
 	if len(groups) == 0 {
@@ -111,29 +132,26 @@ func SSDUserClaimsFromClaims(s *SsdJwtClaims) (*SSDUserClaims, error) {
 	return &ret, nil
 }
 
-// TODO: check that the fields are valid
 func SSDUserClaimsToClaims(c *SSDUserClaims) (SSDClaims, error) {
-	return SSDClaims{
+	ret := SSDClaims{
 		Type:    SSDTokenTypeUser,
 		OrgID:   c.OrgID,
 		IsAdmin: c.IsAdmin,
 		Groups:  c.Groups,
 		UserID:  c.UserID,
-	}, nil
+	}
+	if err := ValidateSSDClaims(&ret); err != nil {
+		return SSDClaims{}, err
+	}
+	return ret, nil
 }
 
 func SSDServiceClaimsFromClaims(s *SsdJwtClaims) (*SSDServiceClaims, error) {
-	if s.SSDCLaims.Type != SSDTokenTypeService {
+	if !sameTokenType(s.SSDCLaims.Type, SSDTokenTypeService) {
 		return nil, fmt.Errorf("cannot parse service claims from type %s", s.SSDCLaims.Type)
 	}
-	if s.SSDCLaims.Service == "" {
-		return nil, fmt.Errorf("required field service is not set in claims")
-	}
-	if s.SSDCLaims.Instance == "" {
-		return nil, fmt.Errorf("required field instance is not set in claims")
-	}
-	if s.SSDCLaims.OrgID == "" {
-		return nil, fmt.Errorf("required field orgID is not set in claims")
+	if err := ValidateSSDClaims(&s.SSDCLaims); err != nil {
+		return nil, err
 	}
 	ret := SSDServiceClaims{
 		Type:     s.SSDCLaims.Type,
@@ -144,20 +162,26 @@ func SSDServiceClaimsFromClaims(s *SsdJwtClaims) (*SSDServiceClaims, error) {
 	return &ret, nil
 }
 
-// TODO: check that the fields are valid
 func SSDServiceClaimsToClaims(c *SSDServiceClaims) (SSDClaims, error) {
-	return SSDClaims{
+	ret := SSDClaims{
 		Type:     SSDTokenTypeService,
 		Service:  c.Service,
 		OrgID:    c.OrgID,
 		Instance: c.Instance,
-	}, nil
+	}
+	if err := ValidateSSDClaims(&ret); err != nil {
+		return SSDClaims{}, err
+	}
+	return ret, nil
 }
 
 func SSDInternalClaimsFromClaims(s *SsdJwtClaims) (*SSDInternalClaims, error) {
-	if s.SSDCLaims.Type != "internal-account/v1" {
+	if !sameTokenType(s.SSDCLaims.Type, SSDTokenTypeInternal) {
 		return nil, fmt.Errorf("cannot parse internal claims from type %s", s.SSDCLaims.Type)
 	}
+	if err := ValidateSSDClaims(&s.SSDCLaims); err != nil {
+		return nil, err
+	}
 	authorizations := s.SSDCLaims.Authorizations
 	if len(authorizations) == 0 {
 		authorizations = []string{}
@@ -170,26 +194,25 @@ func SSDInternalClaimsFromClaims(s *SsdJwtClaims) (*SSDInternalClaims, error) {
 	return &ret, nil
 }
 
-// TODO: check that the fields are valid
 func SSDInternalClaimsToClaims(c *SSDInternalClaims) (SSDClaims, error) {
-	return SSDClaims{
+	ret := SSDClaims{
 		Type:           SSDTokenTypeInternal,
 		Service:        c.Service,
 		Authorizations: c.Authorizations,
-	}, nil
+	}
+	if err := ValidateSSDClaims(&ret); err != nil {
+		return SSDClaims{}, err
+	}
+	return ret, nil
 }
 
 func SSDIntegrationClaimsFromClaims(s *SsdJwtClaims) (*SSDIntegrationClaims, error) {
-	if s.SSDCLaims.Type != SSDTokenTypeIntegration {
+	if !sameTokenType(s.SSDCLaims.Type, SSDTokenTypeIntegration) {
 		return nil, fmt.Errorf("cannot parse integration claims from type %s", s.SSDCLaims.Type)
 	}
-	if s.SSDCLaims.TeamID == "" {
-		return nil, fmt.Errorf("required field teamID is not set in claims")
-	}
-	if s.SSDCLaims.OrgID == "" {
-		return nil, fmt.Errorf("required field orgID is not set in claims")
+	if err := ValidateSSDClaims(&s.SSDCLaims); err != nil {
+		return nil, err
 	}
-
 	ret := SSDIntegrationClaims{
 		Type:   s.SSDCLaims.Type,
 		TeamID: s.SSDCLaims.TeamID,
@@ -199,9 +222,39 @@ func SSDIntegrationClaimsFromClaims(s *SsdJwtClaims) (*SSDIntegrationClaims, err
 }
 
 func SSDIntegrationClaimsToClaims(c *SSDIntegrationClaims) (SSDClaims, error) {
-	return SSDClaims{
+	ret := SSDClaims{
 		Type:   SSDTokenTypeIntegration,
 		OrgID:  c.OrgID,
 		TeamID: c.TeamID,
-	}, nil
+	}
+	if err := ValidateSSDClaims(&ret); err != nil {
+		return SSDClaims{}, err
+	}
+	return ret, nil
+}
+
+// clone returns a deep copy of the claims, so that claims held by a cache
+// or store are not modified through the copies handed to callers.
+func (c *SsdJwtClaims) clone() *SsdJwtClaims {
+	ret := *c
+	ret.Audience = slices.Clone(c.Audience)
+	ret.ExpiresAt = cloneNumericDate(c.ExpiresAt)
+	ret.NotBefore = cloneNumericDate(c.NotBefore)
+	ret.IssuedAt = cloneNumericDate(c.IssuedAt)
+	ret.SSDCLaims.Groups = slices.Clone(c.SSDCLaims.Groups)
+	ret.SSDCLaims.Authorizations = slices.Clone(c.SSDCLaims.Authorizations)
+	ret.SSDCLaims.Custom = cloneCustom(c.SSDCLaims.Type, c.SSDCLaims.Custom)
+	if c.Confirmation != nil {
+		cnf := *c.Confirmation
+		ret.Confirmation = &cnf
+	}
+	return &ret
+}
+
+func cloneNumericDate(d *jwt.NumericDate) *jwt.NumericDate {
+	if d == nil {
+		return nil
+	}
+	ret := *d
+	return &ret
 }
@@ -0,0 +1,135 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// AuthorizationCheck inspects the verified claims for a request, and returns
+// an error describing why access is denied, or nil to allow the request.
+type AuthorizationCheck func(r *http.Request, claims *SsdJwtClaims) error
+
+// Require returns middleware which allows a request only if every check passes.
+// It must be installed after the Verifier's middleware, so the claims are in
+// the request context.  Requests without claims are rejected with 401, and
+// requests failing a check are rejected with 403 and the reason.
+func Require(checks ...AuthorizationCheck) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, found := SSDClaimsFromContext(r.Context())
+			if !found || claims == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthorized"))
+				return
+			}
+			for _, check := range checks {
+				if err := check(r, claims); err != nil {
+					denyAudit(r.Context(), AuditForbidden)
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte("Forbidden: " + err.Error()))
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireTokenType allows only tokens of one of the listed types, in any
+// version, since verified claims are upgraded to the current version.
+func RequireTokenType(types ...string) func(next http.Handler) http.Handler {
+	return Require(TokenTypeCheck(types...))
+}
+
+// RequireAdmin allows only tokens with isAdmin set.
+func RequireAdmin() func(next http.Handler) http.Handler {
+	return Require(AdminCheck())
+}
+
+// RequireOrg allows only tokens whose orgID matches the one returned by
+// orgFunc for the request, for example one taken from the URL.
+func RequireOrg(orgFunc func(r *http.Request) string) func(next http.Handler) http.Handler {
+	return Require(OrgCheck(orgFunc))
+}
+
+// RequireGroup allows only tokens which are a member of at least one of the groups.
+func RequireGroup(groups ...string) func(next http.Handler) http.Handler {
+	return Require(GroupCheck(groups...))
+}
+
+// RequireAuthorization allows only tokens which hold all of the authorizations,
+// as matched by Scope.Covers.
+func RequireAuthorization(authorizations ...string) func(next http.Handler) http.Handler {
+	return Require(AuthorizationsCheck(authorizations...))
+}
+
+// TokenTypeCheck is the check used by RequireTokenType, for use with Require.
+func TokenTypeCheck(types ...string) AuthorizationCheck {
+	return func(r *http.Request, claims *SsdJwtClaims) error {
+		if !slices.ContainsFunc(types, func(t string) bool { return sameTokenType(t, claims.SSDCLaims.Type) }) {
+			return fmt.Errorf("token type %q is not one of %s", claims.SSDCLaims.Type, strings.Join(types, ", "))
+		}
+		return nil
+	}
+}
+
+// AdminCheck is the check used by RequireAdmin, for use with Require.
+func AdminCheck() AuthorizationCheck {
+	return func(r *http.Request, claims *SsdJwtClaims) error {
+		if !claims.SSDCLaims.IsAdmin {
+			return fmt.Errorf("admin access required")
+		}
+		return nil
+	}
+}
+
+// OrgCheck is the check used by RequireOrg, for use with Require.
+func OrgCheck(orgFunc func(r *http.Request) string) AuthorizationCheck {
+	return func(r *http.Request, claims *SsdJwtClaims) error {
+		orgID := orgFunc(r)
+		if orgID == "" || claims.SSDCLaims.OrgID != orgID {
+			return fmt.Errorf("token is not valid for organization %q", orgID)
+		}
+		return nil
+	}
+}
+
+// GroupCheck is the check used by RequireGroup, for use with Require.
+func GroupCheck(groups ...string) AuthorizationCheck {
+	return func(r *http.Request, claims *SsdJwtClaims) error {
+		for _, group := range groups {
+			if slices.Contains(claims.SSDCLaims.Groups, group) {
+				return nil
+			}
+		}
+		return fmt.Errorf("membership in one of %s required", strings.Join(groups, ", "))
+	}
+}
+
+// AuthorizationsCheck is the check used by RequireAuthorization, for use with Require.
+func AuthorizationsCheck(authorizations ...string) AuthorizationCheck {
+	return func(r *http.Request, claims *SsdJwtClaims) error {
+		for _, authorization := range authorizations {
+			if !claims.SSDCLaims.HasAuthorization(authorization) {
+				return fmt.Errorf("authorization %q required", authorization)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequire(t *testing.T) {
+	user := &SsdJwtClaims{SSDCLaims: SSDClaims{
+		Type:   SSDTokenTypeUser,
+		UserID: "alice",
+		OrgID:  "org1",
+		Groups: []string{"dev", "ops"},
+	}}
+	admin := &SsdJwtClaims{SSDCLaims: SSDClaims{
+		Type:    SSDTokenTypeUser,
+		UserID:  "root",
+		OrgID:   "org1",
+		IsAdmin: true,
+	}}
+	internal := &SsdJwtClaims{SSDCLaims: SSDClaims{
+		Type:           SSDTokenTypeInternal,
+		Service:        "svc",
+		Authorizations: []string{"deployments:write", "artifacts:read"},
+	}}
+	legacy := &SsdJwtClaims{SSDCLaims: SSDClaims{
+		Type:           SSDTokenTypeInternal,
+		Service:        "svc",
+		Authorizations: []string{"admin"},
+	}}
+	orgFromHeader := func(r *http.Request) string { return r.Header.Get("X-Org") }
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		claims     *SsdJwtClaims
+		orgHeader  string
+		wantStatus int
+	}{
+		{"no claims", RequireAdmin(), nil, "", http.StatusUnauthorized},
+		{"type allowed", RequireTokenType(SSDTokenTypeUser, SSDTokenTypeService), user, "", http.StatusOK},
+		{"type denied", RequireTokenType(SSDTokenTypeService), user, "", http.StatusForbidden},
+		{"admin allowed", RequireAdmin(), admin, "", http.StatusOK},
+		{"admin denied", RequireAdmin(), user, "", http.StatusForbidden},
+		{"org allowed", RequireOrg(orgFromHeader), user, "org1", http.StatusOK},
+		{"org denied", RequireOrg(orgFromHeader), user, "org2", http.StatusForbidden},
+		{"org missing", RequireOrg(orgFromHeader), user, "", http.StatusForbidden},
+		{"group allowed", RequireGroup("qa", "ops"), user, "", http.StatusOK},
+		{"group denied", RequireGroup("qa"), user, "", http.StatusForbidden},
+		{"authorization allowed", RequireAuthorization("deployments:write"), internal, "", http.StatusOK},
+		{"authorization covered by parent", RequireAuthorization("artifacts/build-1:read"), internal, "", http.StatusOK},
+		{"authorization partial", RequireAuthorization("deployments:write", "policies:read"), internal, "", http.StatusForbidden},
+		{"flat authorization allowed", RequireAuthorization("admin"), legacy, "", http.StatusOK},
+		{"flat authorization denied", RequireAuthorization("admin"), internal, "", http.StatusForbidden},
+		{"composed allowed", Require(TokenTypeCheck(SSDTokenTypeUser), GroupCheck("dev")), user, "", http.StatusOK},
+		{"composed denied", Require(TokenTypeCheck(SSDTokenTypeUser), AdminCheck()), user, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.orgHeader != "" {
+				r.Header.Set("X-Org", tt.orgHeader)
+			}
+			if tt.claims != nil {
+				r = r.WithContext(contextWithToken(r.Context(), tt.claims, "token"))
+			}
+			w := httptest.NewRecorder()
+			tt.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d (%s)", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusForbidden && !strings.HasPrefix(w.Body.String(), "Forbidden: ") {
+				t.Errorf("expected a reason in the body, got %q", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Clock is the source of time for Signers, Verifiers, caches and the
+// background reloading of keys and policies.  Tests can substitute a fake
+// clock, such as the one in the ssdjwttest package, to control expiry and
+// reloads.  Latency metrics always use the system clock.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks on C() until stopped, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock used when no other is configured.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// timeFuncClock adapts the TimeFunc passed to NewVerifier.
+type timeFuncClock struct {
+	SystemClock
+	now TimeFunc
+}
+
+func (c timeFuncClock) Now() time.Time {
+	return c.now()
+}
+
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return SystemClock{}
+	}
+	return c
+}
+
+// WithVerifierClock sets the Verifier's clock, which replaces any TimeFunc
+// passed to NewVerifier.
+func WithVerifierClock(c Clock) VerifierOption {
+	return func(v *Verifier) {
+		v.clock = c
+		v.parseOptions = append(v.parseOptions, jwt.WithTimeFunc(c.Now))
+	}
+}
+
+// WithSignerClock sets the Signer's clock, used by NewClaims.
+func WithSignerClock(c Clock) SignerOption {
+	return func(s *Signer) {
+		s.clock = c
+	}
+}
+
+// WithPolicyClock sets the clock used while maintaining a policy file.
+func WithPolicyClock(c Clock) PolicyOption {
+	return func(p *Policy) {
+		p.clock = c
+	}
+}
+
+func (v *Verifier) now() time.Time {
+	return clockOrSystem(v.clock).Now()
+}
+
+func (s *Signer) now() time.Time {
+	return clockOrSystem(s.clock).Now()
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth/ssdjwttest"
+)
+
+func TestClockExpiry(t *testing.T) {
+	clock := ssdjwttest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	issuer := ssdjwttest.NewIssuer(t, ssdjwttest.WithClock(clock),
+		ssdjwttest.WithVerifierOptions(ssdjwtauth.WithTokenCache(10)))
+	s, v := issuer.Signer, issuer.Verifier
+
+	claims := s.NewClaims(time.Hour, "jti", ssdjwtauth.SSDClaims{Type: ssdjwtauth.SSDTokenTypeUser, UserID: "alice", OrgID: "org1"})
+	if !claims.IssuedAt.Equal(clock.Now()) {
+		t.Errorf("iat = %v, want the fake clock's time %v", claims.IssuedAt, clock.Now())
+	}
+	token, err := s.SignToken(claims)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	steps := []struct {
+		advance time.Duration
+		valid   bool
+	}{
+		{0, true},
+		{time.Hour, true}, // within the leeway
+		{10 * time.Minute, false},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		if _, err := v.VerifyToken(token); (err == nil) != step.valid {
+			t.Errorf("at %v: VerifyToken() error = %v, want valid %v", clock.Now(), err, step.valid)
+		}
+	}
+}
+
+func TestClockKeyRotation(t *testing.T) {
+	clock := ssdjwttest.NewFakeClock(time.Now())
+	dir := t.TempDir()
+	public := ssdjwttest.NewIssuer(t).PublicKeyPEM
+	if err := os.WriteFile(filepath.Join(dir, "k1"), public, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	v, err := ssdjwtauth.NewVerifier(nil, nil, ssdjwtauth.WithVerifierClock(clock))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := v.MaintainKeys(ctx, dir); err != nil {
+		t.Fatalf("MaintainKeys: %v", err)
+	}
+	waitFor(t, "key maintenance to start", func() bool { return clock.Tickers() == 1 })
+
+	if err := os.WriteFile(filepath.Join(dir, "k2"), public, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if got := v.KeyIDs(); !reflect.DeepEqual(got, []string{"k1"}) {
+		t.Errorf("KeyIDs() before tick = %v", got)
+	}
+	clock.Advance(time.Minute)
+	waitFor(t, "keys to reload", func() bool { return reflect.DeepEqual(v.KeyIDs(), []string{"k1", "k2"}) })
+
+	cancel()
+	waitFor(t, "key maintenance to stop", func() bool { return clock.Tickers() == 0 })
+}
+
+// waitFor polls for a background goroutine to react to the fake clock.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCookieName is the cookie used by the UI path when no other
+	// name is configured.
+	DefaultCookieName = "ssd-token"
+
+	// Browsers limit a single cookie (name, value and attributes) to 4096 bytes.
+	// Keep each chunk well below that so the attributes still fit.
+	maxCookieChunkSize = 3800
+
+	// maxCookieChunks bounds how many chunks we will write or read back, so
+	// a hostile client cannot make us concatenate an unbounded number of cookies.
+	maxCookieChunks = 10
+
+	// When a token is split, the primary cookie holds this prefix followed by
+	// the number of chunks.  Neither JWTs nor reference tokens contain a ':'.
+	cookieChunkedPrefix = "chunks:"
+)
+
+// CookieOptions control how SetTokenCookie writes the token cookie.
+// Secure and HttpOnly are always set.
+type CookieOptions struct {
+	// Name of the cookie.  If empty, DefaultCookieName is used.
+	Name string
+	// Domain and Path are passed through to the cookie.  Path defaults to "/".
+	Domain string
+	Path   string
+	// Expires, if non-zero, sets the cookie expiry.  This should normally
+	// match the token's expiry.
+	Expires time.Time
+	// SameSite defaults to http.SameSiteLaxMode if unset.
+	SameSite http.SameSite
+}
+
+func (o CookieOptions) name() string {
+	if o.Name == "" {
+		return DefaultCookieName
+	}
+	return o.Name
+}
+
+func (o CookieOptions) cookie(name string, value string) *http.Cookie {
+	path := o.Path
+	if path == "" {
+		path = "/"
+	}
+	sameSite := o.SameSite
+	if sameSite == 0 || sameSite == http.SameSiteDefaultMode {
+		sameSite = http.SameSiteLaxMode
+	}
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   o.Domain,
+		Path:     path,
+		Expires:  o.Expires,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
+
+func (o CookieOptions) expiredCookie(name string) *http.Cookie {
+	c := o.cookie(name, "")
+	c.Expires = time.Unix(0, 0)
+	c.MaxAge = -1
+	return c
+}
+
+func cookieChunkName(name string, i int) string {
+	return fmt.Sprintf("%s-%d", name, i)
+}
+
+// SetTokenCookie writes the token into one or more cookies.  Tokens larger
+// than a single cookie can hold are split into chunks named <name>-0,
+// <name>-1, ..., and the primary cookie records how many chunks there are.
+// Chunk cookies which the request r carried from a previous, larger token
+// are expired.  r may be nil if the request carried no token cookie.
+func SetTokenCookie(w http.ResponseWriter, r *http.Request, token string, opts CookieOptions) error {
+	name := opts.name()
+	count := 0
+	if len(token) <= maxCookieChunkSize {
+		http.SetCookie(w, opts.cookie(name, token))
+	} else {
+		count = (len(token) + maxCookieChunkSize - 1) / maxCookieChunkSize
+		if count > maxCookieChunks {
+			return fmt.Errorf("token of %d bytes is too large to store in cookies", len(token))
+		}
+		http.SetCookie(w, opts.cookie(name, cookieChunkedPrefix+strconv.Itoa(count)))
+		for i := 0; i < count; i++ {
+			end := (i + 1) * maxCookieChunkSize
+			if end > len(token) {
+				end = len(token)
+			}
+			http.SetCookie(w, opts.cookie(cookieChunkName(name, i), token[i*maxCookieChunkSize:end]))
+		}
+	}
+	expireChunks(w, r, opts, count)
+	return nil
+}
+
+// ClearTokenCookie expires the token cookie and any chunks the request
+// carried for it.  Only cookies named <name>-<n>, as written by
+// SetTokenCookie, are treated as chunks.
+func ClearTokenCookie(w http.ResponseWriter, r *http.Request, opts CookieOptions) {
+	name := opts.name()
+	http.SetCookie(w, opts.expiredCookie(name))
+	expireChunks(w, r, opts, 0)
+}
+
+// expireChunks expires the chunk cookies from the given index on which
+// the request carried.
+func expireChunks(w http.ResponseWriter, r *http.Request, opts CookieOptions, from int) {
+	if r == nil {
+		return
+	}
+	name := opts.name()
+	for i := from; i < maxCookieChunks; i++ {
+		if _, err := r.Cookie(cookieChunkName(name, i)); err == nil {
+			http.SetCookie(w, opts.expiredCookie(cookieChunkName(name, i)))
+		}
+	}
+}
+
+// TokenFromCookies returns the token stored in the named cookie,
+// reassembling it if it was split into chunks by SetTokenCookie.
+// An empty string is returned if the cookie is missing or incomplete.
+func TokenFromCookies(r *http.Request, name string) string {
+	if name == "" {
+		name = DefaultCookieName
+	}
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	if !strings.HasPrefix(c.Value, cookieChunkedPrefix) {
+		return c.Value
+	}
+
+	count, err := strconv.Atoi(strings.TrimPrefix(c.Value, cookieChunkedPrefix))
+	if err != nil || count < 1 || count > maxCookieChunks {
+		return ""
+	}
+	var sb strings.Builder
+	for i := 0; i < count; i++ {
+		chunk, err := r.Cookie(cookieChunkName(name, i))
+		if err != nil {
+			return ""
+		}
+		sb.WriteString(chunk.Value)
+	}
+	return sb.String()
+}
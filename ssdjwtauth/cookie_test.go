@@ -0,0 +1,198 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// liveCookies returns the cookies set by the response, leaving out those
+// it expires.
+func liveCookies(rec *httptest.ResponseRecorder) []*http.Cookie {
+	ret := []*http.Cookie{}
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+func requestWithCookiesFrom(rec *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range liveCookies(rec) {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestSetTokenCookie_roundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		wantCookie int
+		wantErr    bool
+	}{
+		{"small token", "abc.def.ghi", 1, false},
+		{"exactly one chunk", strings.Repeat("a", maxCookieChunkSize), 1, false},
+		{"two chunks", strings.Repeat("b", maxCookieChunkSize+1), 3, false},
+		{"many chunks", strings.Repeat("c", maxCookieChunkSize*4), 5, false},
+		{"too large", strings.Repeat("d", maxCookieChunkSize*maxCookieChunks+1), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			err := SetTokenCookie(rec, nil, tt.token, CookieOptions{Name: "tok"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetTokenCookie() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			cookies := liveCookies(rec)
+			if len(cookies) != tt.wantCookie {
+				t.Errorf("expected %d cookies, got %d", tt.wantCookie, len(cookies))
+			}
+			if all := rec.Result().Cookies(); len(all) != tt.wantCookie {
+				t.Errorf("expected %d Set-Cookie headers, got %d", tt.wantCookie, len(all))
+			}
+			for _, c := range cookies {
+				if !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+					t.Errorf("cookie %s missing Secure/HttpOnly/SameSite", c.Name)
+				}
+			}
+			if got := TokenFromCookies(requestWithCookiesFrom(rec), "tok"); got != tt.token {
+				t.Errorf("TokenFromCookies() returned %d bytes, want %d", len(got), len(tt.token))
+			}
+		})
+	}
+}
+
+func TestSetTokenCookie_replacesChunks(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if err := SetTokenCookie(rec, nil, strings.Repeat("a", maxCookieChunkSize*3), CookieOptions{Name: "tok"}); err != nil {
+		t.Fatalf("SetTokenCookie: %v", err)
+	}
+	r := requestWithCookiesFrom(rec)
+
+	rec = httptest.NewRecorder()
+	if err := SetTokenCookie(rec, r, "abc.def.ghi", CookieOptions{Name: "tok"}); err != nil {
+		t.Fatalf("SetTokenCookie: %v", err)
+	}
+	expired := map[string]bool{}
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge < 0 {
+			expired[c.Name] = true
+		}
+	}
+	for _, c := range r.Cookies() {
+		if c.Name != "tok" && !expired[c.Name] {
+			t.Errorf("stale chunk cookie %s was not expired", c.Name)
+		}
+	}
+	if expired["tok"] {
+		t.Errorf("primary cookie was expired")
+	}
+	if len(expired) != 3 {
+		t.Errorf("expired %d chunk cookies, want the 3 the request carried", len(expired))
+	}
+}
+
+func TestTokenFromCookies_incomplete(t *testing.T) {
+	tests := []struct {
+		name    string
+		cookies []*http.Cookie
+	}{
+		{"no cookie", nil},
+		{"missing chunk", []*http.Cookie{{Name: "tok", Value: "chunks:2"}, {Name: "tok-0", Value: "a"}}},
+		{"bad count", []*http.Cookie{{Name: "tok", Value: "chunks:x"}}},
+		{"too many chunks", []*http.Cookie{{Name: "tok", Value: "chunks:1000"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for _, c := range tt.cookies {
+				r.AddCookie(c)
+			}
+			if got := TokenFromCookies(r, "tok"); got != "" {
+				t.Errorf("TokenFromCookies() = %q, want empty", got)
+			}
+		})
+	}
+}
+
+func TestClearTokenCookie(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "tok", Value: "chunks:2"})
+	r.AddCookie(&http.Cookie{Name: "tok-0", Value: "a"})
+	r.AddCookie(&http.Cookie{Name: "tok-1", Value: "b"})
+	r.AddCookie(&http.Cookie{Name: "other", Value: "c"})
+	r.AddCookie(&http.Cookie{Name: "tok-preferences", Value: "d"})
+	r.AddCookie(&http.Cookie{Name: "tok-1x", Value: "e"})
+	rec := httptest.NewRecorder()
+	ClearTokenCookie(rec, r, CookieOptions{Name: "tok"})
+
+	cleared := map[string]bool{}
+	for _, c := range rec.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			t.Errorf("cookie %s was not expired", c.Name)
+		}
+		cleared[c.Name] = true
+	}
+	for _, name := range []string{"tok", "tok-0", "tok-1"} {
+		if !cleared[name] {
+			t.Errorf("expected cookie %s to be cleared", name)
+		}
+	}
+	for _, name := range []string{"other", "tok-preferences", "tok-1x"} {
+		if cleared[name] {
+			t.Errorf("unrelated cookie %s was cleared", name)
+		}
+	}
+}
+
+func TestMiddlewareFunc_cookie(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	token := signTestToken(t, s, testUserClaims)
+
+	var gotToken string
+	handler := v.MiddlewareFunc(WithTokenCookie("tok"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotToken, _ = SSDTokenFromContext(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	if err := SetTokenCookie(rec, nil, token, CookieOptions{Name: "tok"}); err != nil {
+		t.Fatalf("SetTokenCookie: %v", err)
+	}
+	r := requestWithCookiesFrom(rec)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", w.Code)
+	}
+	if gotToken != token {
+		t.Errorf("token from cookie was not placed in the context")
+	}
+
+	// without the option, the cookie is ignored
+	w = httptest.NewRecorder()
+	v.MiddlewareFunc()(http.NotFoundHandler()).ServeHTTP(w, requestWithCookiesFrom(rec))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without cookie option, got %d", w.Code)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// CustomTokenType describes an application-defined token type, such as
+// "runner/v1", whose claims are carried in the ssd.opsmx.io claim as
+// a struct of the application's choosing.
+//
+// Tokens of a custom type are issued by setting SSDClaims.Type and
+// SSDClaims.Custom, and after verification the decoded struct is found in
+// SSDClaims.Custom (see CustomClaims).  Fields of the custom struct which
+// share a JSON name with a field of SSDClaims, such as "orgID", also
+// populate that field on verification, so generic checks see them.
+// Those fields are held to the same rules as in the built-in types: for
+// example, authorizations must be scopes, and groupRef is not allowed.
+type CustomTokenType struct {
+	// Type is the type and version, of the form "<name>/v<version>".
+	Type string
+	// NewClaims returns a pointer to a new, empty claims struct.
+	NewClaims func() any
+	// Validate checks the custom claims, which have the type returned
+	// by NewClaims.
+	Validate func(claims any) error
+	// Identity optionally converts the custom claims into an Identity,
+	// for IdentityFromClaims.
+	Identity func(claims any) (Identity, error)
+	// Upgrade and Downgrade are as in TokenVersion, and must replace
+	// Custom when the claims struct differs between versions.
+	Upgrade   func(c *SSDClaims) error
+	Downgrade func(c *SSDClaims) error
+}
+
+// RegisterTokenType adds an application-defined token type.  It is intended
+// to be called during initialization.
+func RegisterTokenType(ct CustomTokenType) error {
+	if ct.NewClaims == nil || ct.Validate == nil {
+		return fmt.Errorf("token type %s: NewClaims and Validate are required", ct.Type)
+	}
+	if slices.ContainsFunc(builtinTokenTypes, func(t string) bool { return sameTokenType(t, ct.Type) }) {
+		return fmt.Errorf("token type %s: cannot add a custom version of a built-in type", ct.Type)
+	}
+	claimsType := reflect.TypeOf(ct.NewClaims())
+	if claimsType == nil || claimsType.Kind() != reflect.Pointer {
+		return fmt.Errorf("token type %s: NewClaims must return a pointer", ct.Type)
+	}
+	err := registry.register(TokenVersion{
+		Type: ct.Type,
+		Validate: func(c *SSDClaims) error {
+			if c.Custom == nil {
+				return fmt.Errorf("token type %s requires custom claims", c.Type)
+			}
+			if reflect.TypeOf(c.Custom) != claimsType {
+				return fmt.Errorf("token type %s requires custom claims of type %s, not %T", c.Type, claimsType, c.Custom)
+			}
+			if err := ct.Validate(c.Custom); err != nil {
+				return err
+			}
+			shared, err := sharedFields(c)
+			if err != nil {
+				return err
+			}
+			return customClaimRules.validate(shared)
+		},
+		Upgrade:   ct.Upgrade,
+		Downgrade: ct.Downgrade,
+	})
+	if err != nil {
+		return err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	registry.custom[ct.Type] = ct
+	return nil
+}
+
+// customClaimRules apply to the SSDClaims fields shared with custom claims.
+var customClaimRules = claimRules{
+	forbidden: []string{"groupRef"},
+	maxGroups: maxUserGroups,
+}
+
+// sharedFields returns the SSDClaims fields which the custom claims
+// populate when a token carrying them is verified.
+func sharedFields(c *SSDClaims) (*SSDClaims, error) {
+	b, err := json.Marshal(c.Custom)
+	if err != nil {
+		return nil, err
+	}
+	shared := plainSSDClaims{}
+	if err := json.Unmarshal(b, &shared); err != nil {
+		return nil, fmt.Errorf("custom claims for %s must marshal to a JSON object: %v", c.Type, err)
+	}
+	shared.Type = c.Type
+	return (*SSDClaims)(&shared), nil
+}
+
+// cloneCustom returns a deep copy of the custom claims, made by encoding
+// them and decoding the result into a new struct.
+func cloneCustom(t string, custom any) any {
+	if custom == nil {
+		return nil
+	}
+	if ct, found := registry.customType(t); found {
+		b, err := json.Marshal(custom)
+		if err == nil {
+			ret := ct.NewClaims()
+			if err := json.Unmarshal(b, ret); err == nil {
+				return ret
+			}
+		}
+	}
+	// fall back to copying the struct, which shares any slices or maps
+	v := reflect.ValueOf(custom)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return custom
+	}
+	ret := reflect.New(v.Elem().Type())
+	ret.Elem().Set(v.Elem())
+	return ret.Interface()
+}
+
+func (r *tokenTypeRegistry) customType(t string) (CustomTokenType, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	ct, found := r.custom[t]
+	return ct, found
+}
+
+// CustomClaims returns the custom claims of a verified token, which must be
+// of type T, the type returned by the registered NewClaims.
+func CustomClaims[T any](s *SsdJwtClaims) (T, error) {
+	c, ok := s.SSDCLaims.Custom.(T)
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("token type %s does not carry custom claims of type %T", s.SSDCLaims.Type, zero)
+	}
+	return c, nil
+}
+
+// plainSSDClaims has the fields of SSDClaims without its JSON methods.
+type plainSSDClaims SSDClaims
+
+func (c SSDClaims) MarshalJSON() ([]byte, error) {
+	if c.Custom == nil {
+		return json.Marshal(plainSSDClaims(c))
+	}
+	b, err := json.Marshal(c.Custom)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("custom claims for %s must marshal to a JSON object: %v", c.Type, err)
+	}
+	t, err := json.Marshal(c.Type)
+	if err != nil {
+		return nil, err
+	}
+	fields["type"] = t
+	return json.Marshal(fields)
+}
+
+func (c *SSDClaims) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*plainSSDClaims)(c)); err != nil {
+		return err
+	}
+	ct, found := registry.customType(c.Type)
+	if !found {
+		return nil
+	}
+	custom := ct.NewClaims()
+	if err := json.Unmarshal(data, custom); err != nil {
+		return fmt.Errorf("unable to decode %s claims: %v", c.Type, err)
+	}
+	c.Custom = custom
+	return nil
+}
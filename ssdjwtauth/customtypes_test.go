@@ -0,0 +1,226 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type testRunnerClaims struct {
+	RunnerID string   `json:"runnerID"`
+	OrgID    string   `json:"orgID"`
+	Labels   []string `json:"labels,omitempty"`
+}
+
+// testOperatorClaims share the privileged fields of SSDClaims.
+type testOperatorClaims struct {
+	OperatorID     string   `json:"operatorID"`
+	IsAdmin        bool     `json:"isAdmin,omitempty"`
+	Authorizations []string `json:"authorizations,omitempty"`
+	Groups         []string `json:"groups,omitempty"`
+	GroupRef       string   `json:"groupRef,omitempty"`
+}
+
+type testRunnerIdentity struct {
+	c *testRunnerClaims
+}
+
+func (i testRunnerIdentity) Type() string             { return "runner/v1" }
+func (i testRunnerIdentity) Principal() string        { return i.c.RunnerID }
+func (i testRunnerIdentity) OrgID() string            { return i.c.OrgID }
+func (i testRunnerIdentity) Groups() []string         { return []string{} }
+func (i testRunnerIdentity) IsAdmin() bool            { return false }
+func (i testRunnerIdentity) Authorizations() []string { return []string{} }
+
+func init() {
+	err := RegisterTokenType(CustomTokenType{
+		Type:      "runner/v1",
+		NewClaims: func() any { return &testRunnerClaims{} },
+		Validate: func(claims any) error {
+			c := claims.(*testRunnerClaims)
+			if c.RunnerID == "" {
+				return fmt.Errorf("required field runnerID is not set in claims")
+			}
+			return nil
+		},
+		Identity: func(claims any) (Identity, error) {
+			return testRunnerIdentity{claims.(*testRunnerClaims)}, nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterTokenType(CustomTokenType{
+		Type:      "operator/v1",
+		NewClaims: func() any { return &testOperatorClaims{} },
+		Validate:  func(claims any) error { return nil },
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestCustomTokenType_roundTrip(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	now := time.Now()
+	ssd := SSDClaims{
+		Type:   "runner/v1",
+		Custom: &testRunnerClaims{RunnerID: "runner-7", OrgID: "org1", Labels: []string{"linux"}},
+	}
+	token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "id", ssd))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	claims, err := v.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+
+	runner, err := CustomClaims[*testRunnerClaims](claims)
+	if err != nil {
+		t.Fatalf("CustomClaims: %v", err)
+	}
+	if runner.RunnerID != "runner-7" || len(runner.Labels) != 1 {
+		t.Errorf("unexpected custom claims %+v", runner)
+	}
+	if claims.SSDCLaims.OrgID != "org1" {
+		t.Errorf("expected shared orgID field to populate SSDClaims, got %q", claims.SSDCLaims.OrgID)
+	}
+
+	id, err := IdentityFromClaims(claims)
+	if err != nil {
+		t.Fatalf("IdentityFromClaims: %v", err)
+	}
+	if id.Principal() != "runner-7" || id.Type() != "runner/v1" {
+		t.Errorf("unexpected identity %s %s", id.Type(), id.Principal())
+	}
+
+	if _, err := CustomClaims[*testRunnerClaims](&SsdJwtClaims{SSDCLaims: testUserClaims}); err == nil {
+		t.Errorf("expected CustomClaims to fail for a user token")
+	}
+}
+
+func TestCustomTokenType_validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims SSDClaims
+	}{
+		{"missing custom claims", SSDClaims{Type: "runner/v1"}},
+		{"wrong custom claims type", SSDClaims{Type: "runner/v1", Custom: &testUserClaims}},
+		{"invalid custom claims", SSDClaims{Type: "runner/v1", Custom: &testRunnerClaims{OrgID: "org1"}}},
+	}
+	s, _ := newTestSignerVerifier(t)
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "id", tt.claims)); err == nil {
+				t.Errorf("expected SignToken to fail")
+			}
+		})
+	}
+}
+
+func TestRegisterTokenType_errors(t *testing.T) {
+	validate := func(any) error { return nil }
+	tests := []struct {
+		name string
+		ct   CustomTokenType
+	}{
+		{"no NewClaims", CustomTokenType{Type: "gizmo/v1", Validate: validate}},
+		{"no Validate", CustomTokenType{Type: "gizmo/v1", NewClaims: func() any { return &testRunnerClaims{} }}},
+		{"not a pointer", CustomTokenType{Type: "gizmo/v1", NewClaims: func() any { return testRunnerClaims{} }, Validate: validate}},
+		{"built-in type", CustomTokenType{Type: SSDTokenTypeUser, NewClaims: func() any { return &testRunnerClaims{} }, Validate: validate}},
+		{"built-in type new version", CustomTokenType{Type: "user/v2", NewClaims: func() any { return &testRunnerClaims{} }, Validate: validate}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterTokenType(tt.ct); err == nil {
+				t.Errorf("expected RegisterTokenType to fail")
+			}
+		})
+	}
+}
+
+func TestCustomTokenType_sharedFields(t *testing.T) {
+	manyGroups := make([]string, maxUserGroups+1)
+	for i := range manyGroups {
+		manyGroups[i] = "group"
+	}
+	tests := []struct {
+		name    string
+		custom  *testOperatorClaims
+		wantErr bool
+	}{
+		{"valid", &testOperatorClaims{OperatorID: "op", IsAdmin: true, Authorizations: []string{"runners:*"}, Groups: []string{"ops"}}, false},
+		{"authorization not a scope", &testOperatorClaims{OperatorID: "op", Authorizations: []string{"everything"}}, true},
+		{"too many groups", &testOperatorClaims{OperatorID: "op", Groups: manyGroups}, true},
+		{"group reference", &testOperatorClaims{OperatorID: "op", GroupRef: "sha256:abc"}, true},
+	}
+	s, v := newTestSignerVerifier(t)
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{Type: "operator/v1", Custom: tt.custom})
+			if _, err := s.SignToken(claims); (err != nil) != tt.wantErr {
+				t.Errorf("SignToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			// sign it anyway, bypassing the Signer, and check the verifier agrees
+			token := jwt.NewWithClaims(signingMethod, claims)
+			token.Header["kid"] = s.KeyID
+			tokenString, err := token.SignedString(s.Key)
+			if err != nil {
+				t.Fatalf("SignedString: %v", err)
+			}
+			verified, err := v.VerifyToken(tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && verified.SSDCLaims.IsAdmin != tt.custom.IsAdmin {
+				t.Errorf("expected isAdmin to populate SSDClaims")
+			}
+		})
+	}
+}
+
+func TestCustomTokenType_cacheCopiesCustom(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithTokenCache(10)(v)
+	now := time.Now()
+	ssd := SSDClaims{Type: "runner/v1", Custom: &testRunnerClaims{RunnerID: "runner-7", Labels: []string{"linux"}}}
+	token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "id", ssd))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		claims, err := v.VerifyToken(token)
+		if err != nil {
+			t.Fatalf("VerifyToken: %v", err)
+		}
+		runner, err := CustomClaims[*testRunnerClaims](claims)
+		if err != nil {
+			t.Fatalf("CustomClaims: %v", err)
+		}
+		if runner.RunnerID != "runner-7" || runner.Labels[0] != "linux" {
+			t.Errorf("verification %d returned modified custom claims %+v", i, runner)
+		}
+		runner.RunnerID = "modified"
+		runner.Labels[0] = "modified"
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenCheck is the result of one validation step in a TokenReport.
+type TokenCheck struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// TokenReport explains how a Verifier sees a token.  It never includes
+// the token or its signature.
+type TokenReport struct {
+	Valid    bool           `json:"valid"`
+	Header   map[string]any `json:"header,omitempty"`
+	Claims   map[string]any `json:"claims,omitempty"`
+	KeyID    string         `json:"kid,omitempty"`
+	Issuer   string         `json:"issuer,omitempty"`
+	KeyKnown bool           `json:"keyKnown"`
+	Checks   []TokenCheck   `json:"checks"`
+	// Requires lists what a request must satisfy beyond the token itself:
+	// "dpop" for a DPoP proof, "certificate" for the bound client
+	// certificate, and "once" as a single-use token is accepted only once.
+	Requires []string `json:"requires,omitempty"`
+}
+
+// Requirements reported in TokenReport.Requires.
+const (
+	RequiresDPoP        = "dpop"
+	RequiresCertificate = "certificate"
+	RequiresOnce        = "once"
+)
+
+func (r *TokenReport) passed() bool {
+	return !slices.ContainsFunc(r.Checks, func(c TokenCheck) bool { return !c.Passed })
+}
+
+func (r *TokenReport) check(name string, err error) bool {
+	c := TokenCheck{Name: name, Passed: err == nil}
+	if err != nil {
+		c.Detail = err.Error()
+	}
+	r.Checks = append(r.Checks, c)
+	return err == nil
+}
+
+// DebugHandler returns a handler which explains why a token is accepted or
+// rejected, for support staff.  The token is passed in the "token" form
+// field of a POST, and a TokenReport is returned as JSON.  It must be
+// installed after the Verifier's middleware, and only serves admins.
+func (v *Verifier) DebugHandler() http.Handler {
+	return RequireAdmin()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.PostFormValue("token")
+		if token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Bad Request: token is required"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(v.ExplainToken(token))
+	}))
+}
+
+// ExplainToken runs each validation step on the token independently, and
+// reports the result of each, so all the problems with a token are found
+// rather than only the first.  It has no side effects: a single-use token
+// is not consumed, and nothing is logged, audited or counted in metrics,
+// although a reference token is still passed to the Introspector.
+//
+// Checks which need the request, such as a DPoP proof, cannot be made, and
+// are listed in Requires instead.
+func (v *Verifier) ExplainToken(tokenString string) *TokenReport {
+	report := &TokenReport{Checks: []TokenCheck{}}
+	if IsReferenceToken(tokenString) {
+		claims, err := v.verifyReferenceToken(context.Background(), tokenString)
+		if report.check("reference", err) {
+			report.Issuer = claims.Issuer
+			if v.lifetime != nil {
+				report.check("lifetime", v.lifetime.Check(claims))
+			}
+			v.reportRequirements(report, claims)
+		}
+		report.Valid = report.passed()
+		return report
+	}
+
+	claims := &SsdJwtClaims{}
+	token, parts, err := jwt.NewParser(jwt.WithStrictDecoding()).ParseUnverified(tokenString, claims)
+	if !report.check("format", err) {
+		return report
+	}
+	report.Header = token.Header
+	report.Claims = rawClaims(parts[1])
+	report.Issuer = claims.Issuer
+
+	report.check("algorithm", checkAlgorithm(token))
+	key, err := v.keyForToken(token)
+	report.KeyID, _ = token.Header["kid"].(string)
+	report.KeyKnown = err == nil
+	report.check("key", err)
+	if report.KeyKnown {
+		sig, err := jwt.NewParser(jwt.WithStrictDecoding()).DecodeSegment(parts[2])
+		if err == nil {
+			err = token.Method.Verify(strings.Join(parts[0:2], "."), sig, key)
+		}
+		report.check("signature", err)
+	} else {
+		report.check("signature", fmt.Errorf("no key to verify with"))
+	}
+
+	now := v.now()
+	report.check("issuer", checkEqual("issuer", claims.Issuer, ssdTokenIssuer))
+	report.check("audience", checkAudience(claims.Audience))
+	report.check("expiresAt", checkTime(claims.ExpiresAt, true, func(t time.Time) error {
+		if now.After(t.Add(tokenLeeway)) {
+			return fmt.Errorf("token expired at %s", t.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}))
+	report.check("notBefore", checkTime(claims.NotBefore, false, func(t time.Time) error {
+		if now.Add(tokenLeeway).Before(t) {
+			return fmt.Errorf("token is not valid until %s", t.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}))
+	report.check("issuedAt", checkTime(claims.IssuedAt, false, func(t time.Time) error {
+		if now.Add(tokenLeeway).Before(t) {
+			return fmt.Errorf("token was issued in the future, at %s", t.UTC().Format(time.RFC3339))
+		}
+		return nil
+	}))
+
+	ssd := claims.SSDCLaims
+	err = v.expandGroups(&ssd)
+	if err == nil {
+		err = UpgradeSSDClaims(&ssd)
+	}
+	report.check("ssdClaims", err)
+	if v.lifetime != nil {
+		report.check("lifetime", v.lifetime.Check(claims))
+	}
+	v.reportRequirements(report, claims)
+
+	report.Valid = report.passed()
+	return report
+}
+
+// reportRequirements checks the DPoP and single-use rules as far as the
+// token alone allows, and lists what the request must also satisfy.
+func (v *Verifier) reportRequirements(report *TokenReport, claims *SsdJwtClaims) {
+	cnf := claims.Confirmation
+	if cnf != nil && cnf.JKT != "" {
+		report.Requires = append(report.Requires, RequiresDPoP)
+	} else {
+		report.check("dpop", v.checkDPoPRequired(claims))
+	}
+	if cnf != nil && cnf.X5TS256 != "" {
+		report.Requires = append(report.Requires, RequiresCertificate)
+	}
+	if v.singleUse(claims) {
+		report.Requires = append(report.Requires, RequiresOnce)
+		report.check("replay", v.checkSingleUse(claims))
+	}
+}
+
+// rawClaims decodes the claims segment generically, so fields the
+// SsdJwtClaims struct does not know about are shown too.
+func rawClaims(segment string) map[string]any {
+	b, err := jwt.NewParser().DecodeSegment(segment)
+	if err != nil {
+		return nil
+	}
+	ret := map[string]any{}
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil
+	}
+	return ret
+}
+
+func checkAlgorithm(token *jwt.Token) error {
+	if token.Method.Alg() != signingMethod.Alg() {
+		return fmt.Errorf("algorithm %s is not accepted, expected %s", token.Method.Alg(), signingMethod.Alg())
+	}
+	return nil
+}
+
+func checkEqual(name string, got string, want string) error {
+	if got != want {
+		return fmt.Errorf("%s is %q, expected %q", name, got, want)
+	}
+	return nil
+}
+
+func checkAudience(aud jwt.ClaimStrings) error {
+	if !slices.Contains(aud, ssdTokenAudience) {
+		return fmt.Errorf("audience %v does not include %q", []string(aud), ssdTokenAudience)
+	}
+	return nil
+}
+
+func checkTime(t *jwt.NumericDate, required bool, check func(time.Time) error) error {
+	if t == nil {
+		if required {
+			return fmt.Errorf("claim is required")
+		}
+		return nil
+	}
+	return check(t.Time)
+}
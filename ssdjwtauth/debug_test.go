@@ -0,0 +1,232 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func failedChecks(r *TokenReport) []string {
+	ret := []string{}
+	for _, c := range r.Checks {
+		if !c.Passed {
+			ret = append(ret, c.Name)
+		}
+	}
+	return ret
+}
+
+func TestVerifier_ExplainToken(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	now := time.Now()
+	valid := signTestToken(t, s, testUserClaims)
+
+	expiredUnknownKey := func() string {
+		claims := s.MakeClaims(now.Add(-2*time.Hour), now.Add(-time.Hour), "id", testUserClaims)
+		token := jwt.NewWithClaims(signingMethod, claims)
+		token.Header["kid"] = "other-key"
+		str, err := token.SignedString(s.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return str
+	}()
+	wrongAudienceBadClaims := func() string {
+		claims := s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{Type: SSDTokenTypeUser})
+		claims.Audience = []string{"someone-else"}
+		token := jwt.NewWithClaims(signingMethod, claims)
+		token.Header["kid"] = s.KeyID
+		str, err := token.SignedString(s.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return str
+	}()
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2]))
+
+	tests := []struct {
+		name       string
+		token      string
+		wantValid  bool
+		wantFailed []string
+	}{
+		{"valid", valid, true, []string{}},
+		{"expired with unknown key", expiredUnknownKey, false, []string{"key", "signature", "expiresAt"}},
+		{"wrong audience and claims", wrongAudienceBadClaims, false, []string{"audience", "ssdClaims"}},
+		{"tampered signature", tampered, false, []string{"signature"}},
+		{"non-canonical signature encoding", nonCanonicalToken(valid), false, []string{"signature"}},
+		{"garbage", "not-a-token", false, []string{"format"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := v.ExplainToken(tt.token)
+			if report.Valid != tt.wantValid {
+				t.Errorf("Valid = %v, want %v", report.Valid, tt.wantValid)
+			}
+			if got := failedChecks(report); strings.Join(got, ",") != strings.Join(tt.wantFailed, ",") {
+				t.Errorf("failed checks = %v, want %v", got, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestVerifier_DebugHandler(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	token := signTestToken(t, s, testUserClaims)
+	signature := token[strings.LastIndex(token, ".")+1:]
+	h := v.DebugHandler()
+
+	post := func(claims *SsdJwtClaims) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/debug", strings.NewReader(url.Values{"token": {token}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if claims != nil {
+			r = r.WithContext(contextWithToken(r.Context(), claims, "caller"))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	admin := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, UserID: "root", OrgID: "org1", IsAdmin: true}}
+	w := post(admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 for admin, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), signature) {
+		t.Errorf("response must not contain the token signature")
+	}
+	report := TokenReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("unable to parse report: %v", err)
+	}
+	if !report.Valid || report.KeyID != "testkey" || !report.KeyKnown || report.Issuer != ssdTokenIssuer {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	if w := post(&SsdJwtClaims{SSDCLaims: testUserClaims}); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a non-admin, got %d", w.Code)
+	}
+	if w := post(nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without claims, got %d", w.Code)
+	}
+}
+
+func TestVerifier_ExplainToken_requirements(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	metrics := &recordingMetrics{}
+	sink := NewChannelAuditSink(10)
+	WithReplayCache(NewMemoryReplayCache(0))(v)
+	WithDPoP(DPoPOptions{RequiredTypes: []string{SSDTokenTypeService}})(v)
+	WithVerifierMetrics(metrics)(v)
+	WithVerifierAuditSink(sink)(v)
+
+	_, jwk := newDPoPKey(t)
+	once := testUserClaims
+	once.Once = true
+	admin := testUserClaims
+	admin.IsAdmin = true
+	admin.Once = true
+	now := time.Now()
+	certBound, err := s.SignCertificateBoundToken(s.MakeClaims(now, now.Add(time.Hour), "test-jti", testUserClaims), newTestCertificate(t, "svc"))
+	if err != nil {
+		t.Fatalf("SignCertificateBoundToken: %v", err)
+	}
+	onceToken := signTestToken(t, s, once)
+
+	tests := []struct {
+		name         string
+		token        string
+		wantValid    bool
+		wantFailed   []string
+		wantRequires []string
+	}{
+		{"plain", signTestToken(t, s, testUserClaims), true, []string{}, nil},
+		{"dpop bound", signBoundToken(t, s, jwk), true, []string{}, []string{RequiresDPoP}},
+		{"certificate bound", certBound, true, []string{}, []string{RequiresCertificate}},
+		{"single use", onceToken, true, []string{}, []string{RequiresOnce}},
+		{"single use admin", signTestToken(t, s, admin), true, []string{}, []string{RequiresOnce}},
+		{"dpop required", signTestToken(t, s, testServiceClaims), false, []string{"dpop"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := v.ExplainToken(tt.token)
+			if report.Valid != tt.wantValid {
+				t.Errorf("Valid = %v, want %v", report.Valid, tt.wantValid)
+			}
+			if got := failedChecks(report); strings.Join(got, ",") != strings.Join(tt.wantFailed, ",") {
+				t.Errorf("failed checks = %v, want %v", got, tt.wantFailed)
+			}
+			if strings.Join(report.Requires, ",") != strings.Join(tt.wantRequires, ",") {
+				t.Errorf("requires = %v, want %v", report.Requires, tt.wantRequires)
+			}
+		})
+	}
+
+	if got := metrics.take(); len(got) != 0 {
+		t.Errorf("ExplainToken recorded metrics %v", got)
+	}
+	if len(sink.C) != 0 {
+		t.Errorf("ExplainToken recorded %d audit events", len(sink.C))
+	}
+	if _, err := v.VerifyToken(onceToken); err != nil {
+		t.Errorf("ExplainToken consumed the single-use token: %v", err)
+	}
+}
+
+func TestVerifier_ExplainToken_reference(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	_, public := testKeyPEMs(t)
+	metrics := &recordingMetrics{}
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, nil,
+		WithIntrospector(StoreIntrospector{store}),
+		WithReplayCache(NewMemoryReplayCache(0)),
+		WithVerifierMetrics(metrics))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	once := testUserClaims
+	once.Once = true
+	now := time.Now()
+	ref, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "ref-jti", once))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+
+	report := v.ExplainToken(ref)
+	if !report.Valid || report.Issuer != ssdTokenIssuer {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if strings.Join(report.Requires, ",") != RequiresOnce {
+		t.Errorf("requires = %v, want [%s]", report.Requires, RequiresOnce)
+	}
+	if got := metrics.take(); len(got) != 0 {
+		t.Errorf("ExplainToken recorded metrics %v", got)
+	}
+	if _, err := v.VerifyToken(ref); err != nil {
+		t.Errorf("ExplainToken consumed the single-use token: %v", err)
+	}
+	if report := v.ExplainToken("ssdref_unknown"); report.Valid {
+		t.Errorf("unknown reference token reported as valid")
+	}
+}
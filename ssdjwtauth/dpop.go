@@ -0,0 +1,286 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	dpopHeader       = "DPoP"
+	dpopScheme       = "DPoP"
+	dpopProofType    = "dpop+jwt"
+	defaultDPoPProof = 60 * time.Second
+)
+
+// dpopAlgorithms are the asymmetric algorithms accepted for DPoP proofs.
+var dpopAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// DPoPOptions configure how the middleware checks DPoP proofs (RFC 9449).
+// Tokens carrying a cnf.jkt claim always require a valid proof, whether or
+// not these options are set.
+type DPoPOptions struct {
+	// RequiredTypes lists token types which must be DPoP bound, in any
+	// version, so a plain bearer token of that type is rejected.
+	RequiredTypes []string
+	// ProofWindow is how far the proof's iat may be from the current time.
+	// It defaults to 60 seconds.
+	ProofWindow time.Duration
+	// TrustForwardedProto uses the X-Forwarded-Proto header to determine
+	// the scheme of the request URL, when behind a TLS-terminating proxy.
+	TrustForwardedProto bool
+	// ReplayCache records proof jtis to reject replayed proofs.  It
+	// defaults to a MemoryReplayCache private to the Verifier, which uses
+	// the Verifier's clock.
+	ReplayCache ReplayCache
+}
+
+// WithDPoP configures DPoP proof checking in the Verifier's middleware.
+func WithDPoP(opts DPoPOptions) VerifierOption {
+	return func(v *Verifier) {
+		v.dpop = opts
+	}
+}
+
+// DPoPProofClaims are the claims of a DPoP proof JWT.
+type DPoPProofClaims struct {
+	jwt.RegisteredClaims
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+}
+
+// BindDPoPKey makes the claims sender-constrained to the holder of the
+// private key matching the JWK, by setting the cnf.jkt claim.
+func (c *SsdJwtClaims) BindDPoPKey(key JWK) error {
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		return err
+	}
+	if c.Confirmation == nil {
+		c.Confirmation = &Confirmation{}
+	}
+	c.Confirmation.JKT = jkt
+	return nil
+}
+
+// MakeDPoPProof creates a DPoP proof for one request, for use by Go clients.
+// The key must be an *rsa.PrivateKey or *ecdsa.PrivateKey.
+func MakeDPoPProof(key crypto.PrivateKey, method string, url string, accessToken string, now time.Time) (string, error) {
+	var signing jwt.SigningMethod
+	var public crypto.PublicKey
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signing, public = jwt.SigningMethodRS256, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().Name {
+		case "P-256":
+			signing = jwt.SigningMethodES256
+		case "P-384":
+			signing = jwt.SigningMethodES384
+		case "P-521":
+			signing = jwt.SigningMethodES512
+		default:
+			return "", fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+		public = &k.PublicKey
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+	jwk, err := JWKFromPublicKey(public)
+	if err != nil {
+		return "", err
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	claims := DPoPProofClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       base64.RawURLEncoding.EncodeToString(jti),
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		HTM: method,
+		HTU: url,
+	}
+	if accessToken != "" {
+		claims.ATH = accessTokenHash(accessToken)
+	}
+	token := jwt.NewWithClaims(signing, claims)
+	token.Header["typ"] = dpopProofType
+	token.Header["jwk"] = jwk
+	return token.SignedString(key)
+}
+
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// checkDPoP enforces DPoP for a verified token presented on a request.
+func (v *Verifier) checkDPoP(r *http.Request, accessToken string, claims *SsdJwtClaims) error {
+	jkt := ""
+	if claims.Confirmation != nil {
+		jkt = claims.Confirmation.JKT
+	}
+	if jkt == "" {
+		return v.checkDPoPRequired(claims)
+	}
+	if r == nil {
+		return fmt.Errorf("DPoP-bound token must be verified with its request")
+	}
+	return v.verifyDPoPProof(r, accessToken, jkt)
+}
+
+// checkDPoPRequired rejects a token which is not DPoP bound, if its type
+// must be.  Types are matched in any version, since verified claims have
+// been upgraded to the current version.
+func (v *Verifier) checkDPoPRequired(claims *SsdJwtClaims) error {
+	if slices.ContainsFunc(v.dpop.RequiredTypes, func(t string) bool { return sameTokenType(t, claims.SSDCLaims.Type) }) {
+		return fmt.Errorf("token type %s must be DPoP bound", claims.SSDCLaims.Type)
+	}
+	return nil
+}
+
+func (v *Verifier) verifyDPoPProof(r *http.Request, accessToken string, jkt string) error {
+	// RFC 9449 section 7.1: a bound token must not be accepted as a bearer
+	// token, which a client could send without proving possession.
+	if authScheme(r) != dpopScheme {
+		return fmt.Errorf("DPoP-bound token must use the %s authorization scheme", dpopScheme)
+	}
+	proofs := r.Header.Values(dpopHeader)
+	if len(proofs) != 1 {
+		return fmt.Errorf("exactly one DPoP proof is required")
+	}
+
+	var proofKey JWK
+	claims := &DPoPProofClaims{}
+	_, err := jwt.ParseWithClaims(proofs[0], claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != dpopProofType {
+			return nil, fmt.Errorf("DPoP proof typ must be %s", dpopProofType)
+		}
+		key, err := jwkFromHeader(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		proofKey = key
+		return key.PublicKey()
+	}, jwt.WithValidMethods(dpopAlgorithms), jwt.WithoutClaimsValidation())
+	if err != nil {
+		return fmt.Errorf("invalid DPoP proof: %v", err)
+	}
+
+	thumbprint, err := proofKey.Thumbprint()
+	if err != nil || thumbprint != jkt {
+		return fmt.Errorf("DPoP proof key does not match the token")
+	}
+	if !strings.EqualFold(claims.HTM, r.Method) {
+		return fmt.Errorf("DPoP proof htm does not match the request")
+	}
+	if !sameURL(claims.HTU, v.requestURL(r)) {
+		return fmt.Errorf("DPoP proof htu does not match the request")
+	}
+	if claims.ATH != accessTokenHash(accessToken) {
+		return fmt.Errorf("DPoP proof ath does not match the token")
+	}
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return fmt.Errorf("DPoP proof requires jti and iat")
+	}
+	window := v.dpop.ProofWindow
+	if window == 0 {
+		window = defaultDPoPProof
+	}
+	now := v.now()
+	if claims.IssuedAt.Before(now.Add(-window)) || claims.IssuedAt.After(now.Add(window)) {
+		return fmt.Errorf("DPoP proof iat is outside the allowed window")
+	}
+	id := "dpop:" + thumbprint + ":" + claims.ID
+	var fresh bool
+	if v.dpop.ReplayCache != nil {
+		fresh, err = v.dpop.ReplayCache.MarkUsed(id, claims.IssuedAt.Add(window))
+	} else {
+		fresh, err = v.dpopReplay.markUsed(now, id, claims.IssuedAt.Add(window))
+	}
+	if err != nil {
+		return fmt.Errorf("replay cache: %v", err)
+	}
+	if !fresh {
+		return fmt.Errorf("DPoP proof has already been used")
+	}
+	return nil
+}
+
+// jwkFromHeader converts the "jwk" header of a proof, which has been
+// decoded as generic JSON.  It must not contain a private key.
+func jwkFromHeader(h any) (JWK, error) {
+	m, ok := h.(map[string]any)
+	if !ok {
+		return JWK{}, fmt.Errorf("DPoP proof requires a jwk header")
+	}
+	if _, found := m["d"]; found {
+		return JWK{}, fmt.Errorf("DPoP proof jwk must not contain a private key")
+	}
+	str := func(name string) string {
+		s, _ := m[name].(string)
+		return s
+	}
+	return JWK{
+		KTY: str("kty"),
+		E:   str("e"),
+		N:   str("n"),
+		CRV: str("crv"),
+		X:   str("x"),
+		Y:   str("y"),
+	}, nil
+}
+
+// requestURL is the htu a client should have used for the request,
+// without the query or fragment.
+func (v *Verifier) requestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if v.dpop.TrustForwardedProto {
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			scheme = proto
+		}
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// sameURL compares the htu in a proof to the request URL, ignoring any
+// query and fragment, and the case of the scheme and host.
+func sameURL(htu string, requestURL string) bool {
+	htu, _, _ = strings.Cut(htu, "#")
+	htu, _, _ = strings.Cut(htu, "?")
+	hScheme, hRest, _ := strings.Cut(htu, "://")
+	rScheme, rRest, _ := strings.Cut(requestURL, "://")
+	hHost, hPath, _ := strings.Cut(hRest, "/")
+	rHost, rPath, _ := strings.Cut(rRest, "/")
+	return strings.EqualFold(hScheme, rScheme) && strings.EqualFold(hHost, rHost) && hPath == rPath
+}
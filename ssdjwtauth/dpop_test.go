@@ -0,0 +1,318 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newDPoPKey(t *testing.T) (*ecdsa.PrivateKey, JWK) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	jwk, err := JWKFromPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("JWKFromPublicKey: %v", err)
+	}
+	return key, jwk
+}
+
+func signBoundToken(t *testing.T, s *Signer, jwk JWK) string {
+	t.Helper()
+	now := time.Now()
+	claims := s.MakeClaims(now, now.Add(time.Hour), "test-jti", testUserClaims)
+	if err := claims.BindDPoPKey(jwk); err != nil {
+		t.Fatalf("BindDPoPKey: %v", err)
+	}
+	token, err := s.SignToken(claims)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	return token
+}
+
+func TestJWKThumbprint(t *testing.T) {
+	// RFC 7638 section 3.1 example.
+	jwk := JWK{
+		KTY: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+	}
+	got, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("Thumbprint: %v", err)
+	}
+	if want := "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("Thumbprint() = %s, want %s", got, want)
+	}
+}
+
+func TestJWKRoundTrip(t *testing.T) {
+	ecKey, ecJWK := newDPoPKey(t)
+	testKeyPEMs(t)
+	rsaJWK, err := JWKFromPublicKey(&testKey.PublicKey)
+	if err != nil {
+		t.Fatalf("JWKFromPublicKey: %v", err)
+	}
+	tests := []struct {
+		name string
+		jwk  JWK
+		want crypto.PublicKey
+	}{
+		{"ec", ecJWK, &ecKey.PublicKey},
+		{"rsa", rsaJWK, &testKey.PublicKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.jwk.PublicKey()
+			if err != nil {
+				t.Fatalf("PublicKey: %v", err)
+			}
+			if !got.(interface{ Equal(crypto.PublicKey) bool }).Equal(tt.want) {
+				t.Errorf("PublicKey() did not round trip")
+			}
+		})
+	}
+
+	bad := ecJWK
+	bad.X = rsaJWK.E
+	if _, err := bad.PublicKey(); err == nil {
+		t.Errorf("expected error for point not on curve")
+	}
+}
+
+func TestDPoPMiddleware(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	key, jwk := newDPoPKey(t)
+	otherKey, _ := newDPoPKey(t)
+	token := signBoundToken(t, s, jwk)
+	const url = "http://example.com/api/v1/things"
+	now := time.Now()
+
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	proof := func(key crypto.PrivateKey, method, url, accessToken string, at time.Time) string {
+		p, err := MakeDPoPProof(key, method, url, accessToken, at)
+		if err != nil {
+			t.Fatalf("MakeDPoPProof: %v", err)
+		}
+		return p
+	}
+
+	replayed := proof(key, "GET", url, token, now)
+	tests := []struct {
+		name   string
+		token  string
+		proofs []string
+		want   int
+	}{
+		{"valid", token, []string{proof(key, "GET", url, token, now)}, http.StatusOK},
+		{"query ignored", token, []string{proof(key, "GET", url+"?x=1", token, now)}, http.StatusOK},
+		{"first use", token, []string{replayed}, http.StatusOK},
+		{"replayed jti", token, []string{replayed}, http.StatusUnauthorized},
+		{"missing proof", token, nil, http.StatusUnauthorized},
+		{"two proofs", token, []string{proof(key, "GET", url, token, now), proof(key, "GET", url, token, now)}, http.StatusUnauthorized},
+		{"wrong method", token, []string{proof(key, "POST", url, token, now)}, http.StatusUnauthorized},
+		{"wrong url", token, []string{proof(key, "GET", "http://example.com/other", token, now)}, http.StatusUnauthorized},
+		{"stale iat", token, []string{proof(key, "GET", url, token, now.Add(-5*time.Minute))}, http.StatusUnauthorized},
+		{"future iat", token, []string{proof(key, "GET", url, token, now.Add(5*time.Minute))}, http.StatusUnauthorized},
+		{"wrong key", token, []string{proof(otherKey, "GET", url, token, now)}, http.StatusUnauthorized},
+		{"ath mismatch", token, []string{proof(key, "GET", url, "other-token", now)}, http.StatusUnauthorized},
+		{"not a jwt", token, []string{"garbage"}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", url, nil)
+			r.Header.Set("Authorization", "DPoP "+tt.token)
+			for _, p := range tt.proofs {
+				r.Header.Add("DPoP", p)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusUnauthorized && len(tt.proofs) > 0 && w.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestDPoPRequiredTypes(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithDPoP(DPoPOptions{RequiredTypes: []string{SSDTokenTypeUser}})(v)
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, s, testUserClaims))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("unbound user token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	service := SSDClaims{Type: SSDTokenTypeService, OrgID: "org1", Service: "svc", Instance: "svc-1"}
+	r = httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, s, service))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("unbound service token: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDPoPForwardedProto(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithDPoP(DPoPOptions{TrustForwardedProto: true})(v)
+	key, jwk := newDPoPKey(t)
+	token := signBoundToken(t, s, jwk)
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	p, err := MakeDPoPProof(key, "GET", "https://example.com/x", token, time.Now())
+	if err != nil {
+		t.Fatalf("MakeDPoPProof: %v", err)
+	}
+	r := httptest.NewRequest("GET", "http://example.com/x", nil)
+	r.Header.Set("Authorization", "DPoP "+token)
+	r.Header.Set("DPoP", p)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestDPoPBearerScheme(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	key, jwk := newDPoPKey(t)
+	token := signBoundToken(t, s, jwk)
+	const url = "http://example.com/x"
+
+	for _, scheme := range []string{"Bearer", "DPoP"} {
+		t.Run(scheme, func(t *testing.T) {
+			p, err := MakeDPoPProof(key, "GET", url, token, time.Now())
+			if err != nil {
+				t.Fatalf("MakeDPoPProof: %v", err)
+			}
+			r := httptest.NewRequest("GET", url, nil)
+			r.Header.Set("Authorization", scheme+" "+token)
+			r.Header.Set("DPoP", p)
+			_, err = v.VerifyRequest(r, TokenFromHeaders(r))
+			if (err != nil) != (scheme == "Bearer") {
+				t.Errorf("VerifyRequest() error = %v", err)
+			}
+		})
+	}
+
+	if _, err := v.VerifyToken(token); err == nil {
+		t.Errorf("expected VerifyToken to reject a DPoP-bound token")
+	}
+}
+
+func TestDPoPCheckedBeforeReplay(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithReplayCache(NewMemoryReplayCache(0))(v)
+	key, jwk := newDPoPKey(t)
+	otherKey, _ := newDPoPKey(t)
+	const url = "http://example.com/x"
+
+	now := time.Now()
+	once := testUserClaims
+	once.Once = true
+	claims := s.MakeClaims(now, now.Add(time.Hour), "once-jti", once)
+	if err := claims.BindDPoPKey(jwk); err != nil {
+		t.Fatalf("BindDPoPKey: %v", err)
+	}
+	token, err := s.SignToken(claims)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	request := func(key crypto.PrivateKey) *http.Request {
+		p, err := MakeDPoPProof(key, "GET", url, token, time.Now())
+		if err != nil {
+			t.Fatalf("MakeDPoPProof: %v", err)
+		}
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Authorization", "DPoP "+token)
+		r.Header.Set("DPoP", p)
+		return r
+	}
+	if _, err := v.VerifyRequest(request(otherKey), token); err == nil {
+		t.Fatalf("expected a proof with the wrong key to be rejected")
+	}
+	if _, err := v.VerifyRequest(request(key), token); err != nil {
+		t.Errorf("token was consumed by a request with the wrong key: %v", err)
+	}
+	if _, err := v.VerifyRequest(request(key), token); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("expected the second use to be rejected as replayed, got %v", err)
+	}
+}
+
+func TestDPoPReplay_timeFunc(t *testing.T) {
+	s, _ := newTestSignerVerifier(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeFunc := TimeFunc(func() time.Time { return now })
+	_, public := testKeyPEMs(t)
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, &timeFunc)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	key, jwk := newDPoPKey(t)
+	claims := s.MakeClaims(now, now.Add(time.Hour), "test-jti", testUserClaims)
+	if err := claims.BindDPoPKey(jwk); err != nil {
+		t.Fatalf("BindDPoPKey: %v", err)
+	}
+	token, err := s.SignToken(claims)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	const url = "http://example.com/x"
+	proof, err := MakeDPoPProof(key, "GET", url, token, now)
+	if err != nil {
+		t.Fatalf("MakeDPoPProof: %v", err)
+	}
+	request := func() *http.Request {
+		r := httptest.NewRequest("GET", url, nil)
+		r.Header.Set("Authorization", "DPoP "+token)
+		r.Header.Set("DPoP", proof)
+		return r
+	}
+	if _, err := v.VerifyRequest(request(), token); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, err := v.VerifyRequest(request(), token); err == nil {
+		t.Errorf("expected the replayed proof to be rejected")
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// The seed corpus for these targets is in testdata/fuzz.  Run a target
+// with, for example:
+//
+//	go test -run XXX -fuzz FuzzVerifyToken ./ssdjwtauth
+
+func FuzzTokenFromHeaders(f *testing.F) {
+	f.Add("Bearer abc.def.ghi", "")
+	f.Add("", "Bearer abc")
+	f.Fuzz(func(t *testing.T, authorization string, opsmx string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header["Authorization"] = []string{authorization}
+		r.Header["X-Opsmx-Auth"] = []string{opsmx}
+		token := TokenFromHeaders(r)
+		header := authorization
+		if header == "" {
+			header = opsmx
+		}
+		if token != "Header does not contain TOKEN" && !strings.Contains(header, token) {
+			t.Errorf("TokenFromHeaders() = %q, which is not part of the header %q", token, header)
+		}
+	})
+}
+
+func FuzzVerifyToken(f *testing.F) {
+	s, v := newTestSignerVerifier(f)
+	valid := signTestToken(f, s, testUserClaims)
+	f.Add(valid)
+	f.Add(valid[:len(valid)-1])
+	f.Add(strings.Replace(valid, ".", "..", 1))
+	f.Fuzz(func(t *testing.T, token string) {
+		claims, err := v.VerifyToken(token)
+		if err == nil && token != valid {
+			t.Errorf("VerifyToken accepted a token which was not issued: %q, claims %+v", token, claims)
+		}
+		// the verifier needs nothing from the request, so ExplainToken
+		// must reach the same verdict
+		report := v.ExplainToken(token)
+		if explained := report.Valid && len(report.Requires) == 0; explained != (err == nil) {
+			t.Errorf("ExplainToken valid = %v, but VerifyToken error = %v, for %q", explained, err, token)
+		}
+	})
+}
+
+func FuzzFromClaims(f *testing.F) {
+	for _, ssd := range []SSDClaims{testUserClaims, testServiceClaims, testInternalClaims, testIntegrationClaims} {
+		b, err := json.Marshal(SsdJwtClaims{SSDCLaims: ssd})
+		if err != nil {
+			f.Fatalf("Marshal: %v", err)
+		}
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		claims := &SsdJwtClaims{}
+		if err := json.Unmarshal(data, claims); err != nil {
+			return
+		}
+		// Whatever is accepted must convert back to the same claims,
+		// unless its field values could not be issued.
+		if u, err := SSDUserClaimsFromClaims(claims); err == nil {
+			ssd, err := SSDUserClaimsToClaims(u)
+			if err != nil {
+				if ValidateSSDClaims(&claims.SSDCLaims) == nil {
+					t.Fatalf("SSDUserClaimsToClaims: %v", err)
+				}
+			} else if u2, err := SSDUserClaimsFromClaims(&SsdJwtClaims{SSDCLaims: ssd}); err != nil || !reflect.DeepEqual(u, u2) {
+				t.Errorf("user claims did not round trip: %+v, %+v, %v", u, u2, err)
+			}
+		}
+		if c, err := SSDServiceClaimsFromClaims(claims); err == nil {
+			ssd, err := SSDServiceClaimsToClaims(c)
+			if err != nil {
+				if ValidateSSDClaims(&claims.SSDCLaims) == nil {
+					t.Fatalf("SSDServiceClaimsToClaims: %v", err)
+				}
+			} else if c2, err := SSDServiceClaimsFromClaims(&SsdJwtClaims{SSDCLaims: ssd}); err != nil || !reflect.DeepEqual(c, c2) {
+				t.Errorf("service claims did not round trip: %+v, %+v, %v", c, c2, err)
+			}
+		}
+		if c, err := SSDInternalClaimsFromClaims(claims); err == nil {
+			ssd, err := SSDInternalClaimsToClaims(c)
+			if err != nil {
+				if ValidateSSDClaims(&claims.SSDCLaims) == nil {
+					t.Fatalf("SSDInternalClaimsToClaims: %v", err)
+				}
+			} else if c2, err := SSDInternalClaimsFromClaims(&SsdJwtClaims{SSDCLaims: ssd}); err != nil || !reflect.DeepEqual(c, c2) {
+				t.Errorf("internal claims did not round trip: %+v, %+v, %v", c, c2, err)
+			}
+		}
+		if c, err := SSDIntegrationClaimsFromClaims(claims); err == nil {
+			ssd, err := SSDIntegrationClaimsToClaims(c)
+			if err != nil {
+				if ValidateSSDClaims(&claims.SSDCLaims) == nil {
+					t.Fatalf("SSDIntegrationClaimsToClaims: %v", err)
+				}
+			} else if c2, err := SSDIntegrationClaimsFromClaims(&SsdJwtClaims{SSDCLaims: ssd}); err != nil || !reflect.DeepEqual(c, c2) {
+				t.Errorf("integration claims did not round trip: %+v, %+v, %v", c, c2, err)
+			}
+		}
+		IdentityFromClaims(claims)
+	})
+}
+
+func FuzzReadKeyFiles(f *testing.F) {
+	_, public := testKeyPEMs(f)
+	f.Add("key1", public)
+	f.Add(".hidden", []byte("ignored"))
+	f.Add("junk", []byte("-----BEGIN PUBLIC KEY-----\n-----END PUBLIC KEY-----\n"))
+	f.Fuzz(func(t *testing.T, name string, content []byte) {
+		if name == "" || len(name) > 64 || filepath.Base(name) != name || strings.ContainsAny(name, "/\\\x00") || name == "." || name == ".." {
+			return
+		}
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o600); err != nil {
+			return
+		}
+		files, err := readKeyFiles(dir)
+		if err != nil {
+			t.Fatalf("readKeyFiles: %v", err)
+		}
+		want := map[string][]byte{}
+		if alphanumeric(name[0]) {
+			want[name] = content
+		}
+		if len(files) != len(want) || (len(want) == 1 && string(files[name]) != string(content)) {
+			t.Errorf("readKeyFiles() = %q, want %q", files, want)
+		}
+		parseKeys(files)
+	})
+}
+
+// randomIdentifier returns a value valid for identifier claims.
+func randomIdentifier(r *rand.Rand) string {
+	const first = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	const rest = first + ".-_:"
+	b := []byte{first[r.Intn(len(first))]}
+	for i := r.Intn(20); i > 0; i-- {
+		b = append(b, rest[r.Intn(len(rest))])
+	}
+	return string(b)
+}
+
+// randomScopeWord returns a value valid as a scope resource.
+func randomScopeWord(r *rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz"
+	b := []byte{}
+	for i := r.Intn(10); i >= 0; i-- {
+		b = append(b, letters[r.Intn(len(letters))])
+	}
+	return string(b)
+}
+
+func TestPropertyRoundTrip(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	property := func(seed int64) bool {
+		r := rand.New(rand.NewSource(seed))
+		claims := []SSDClaims{
+			{Type: SSDTokenTypeUser, UserID: randomIdentifier(r), OrgID: randomIdentifier(r), Groups: []string{randomIdentifier(r)}, IsAdmin: r.Intn(2) == 0},
+			{Type: SSDTokenTypeService, Service: randomIdentifier(r), Instance: randomIdentifier(r), OrgID: randomIdentifier(r)},
+			{Type: SSDTokenTypeInternal, Service: randomIdentifier(r), Authorizations: []string{randomScopeWord(r) + ":read"}},
+			{Type: SSDTokenTypeIntegration, TeamID: randomIdentifier(r), OrgID: randomIdentifier(r)},
+		}
+		for _, ssd := range claims {
+			now := time.Now()
+			token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), randomIdentifier(r), ssd))
+			if err != nil {
+				t.Errorf("SignToken(%+v): %v", ssd, err)
+				return false
+			}
+			got, err := v.VerifyToken(token)
+			if err != nil {
+				t.Errorf("VerifyToken(%+v): %v", ssd, err)
+				return false
+			}
+			if !reflect.DeepEqual(got.SSDCLaims, ssd) {
+				t.Errorf("claims = %+v, want %+v", got.SSDCLaims, ssd)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 10}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPropertyMutatedTokensNeverVerify(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	token := signTestToken(t, s, testUserClaims)
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_."
+	for i := range token {
+		for _, c := range []byte{alphabet[(strings.IndexByte(alphabet, token[i])+1)%len(alphabet)], token[i] ^ 1} {
+			mutated := token[:i] + string(c) + token[i+1:]
+			if mutated == token {
+				continue
+			}
+			if _, err := v.VerifyToken(mutated); err == nil {
+				t.Errorf("token mutated at %d of %d (%q to %q) was accepted", i, len(token), token[i], c)
+			}
+			if v.ExplainToken(mutated).Valid {
+				t.Errorf("token mutated at %d of %d (%q to %q) was explained as valid", i, len(token), token[i], c)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// GroupEncoder replaces a list of groups with a compact reference, which
+// is carried in the groupRef claim in place of the groups.  This keeps
+// tokens for users in many groups small enough for cookies and headers.
+type GroupEncoder interface {
+	EncodeGroups(groups []string) (string, error)
+}
+
+// GroupResolver expands a reference created by a GroupEncoder back into
+// the list of groups.  Verifiers use it so callers always see Groups.
+type GroupResolver interface {
+	ResolveGroups(ref string) ([]string, error)
+}
+
+// WithGroupEncoder makes the Signer replace the groups with a reference from
+// enc when a token has more than threshold groups.  Verifiers of these tokens
+// need a matching GroupResolver, see WithGroupResolver.
+func WithGroupEncoder(enc GroupEncoder, threshold int) SignerOption {
+	return func(s *Signer) {
+		s.groupEncoder = enc
+		s.groupThreshold = threshold
+	}
+}
+
+// WithGroupResolver lets the Verifier expand group references in tokens.
+// Without one, tokens carrying a group reference are rejected.
+func WithGroupResolver(r GroupResolver) VerifierOption {
+	return func(v *Verifier) {
+		v.groupResolver = r
+	}
+}
+
+func (s *Signer) encodeGroups(c *SSDClaims) error {
+	if s.groupEncoder == nil || len(c.Groups) <= s.groupThreshold {
+		return nil
+	}
+	ref, err := s.groupEncoder.EncodeGroups(c.Groups)
+	if err != nil {
+		return fmt.Errorf("unable to encode groups: %v", err)
+	}
+	c.Groups = nil
+	c.GroupRef = ref
+	return nil
+}
+
+func (v *Verifier) expandGroups(c *SSDClaims) error {
+	if c.GroupRef == "" {
+		return nil
+	}
+	if len(c.Groups) > 0 {
+		return fmt.Errorf("fields groups and groupRef cannot both be set")
+	}
+	if v.groupResolver == nil {
+		return fmt.Errorf("token has a group reference, but no group resolver is configured")
+	}
+	groups, err := v.groupResolver.ResolveGroups(c.GroupRef)
+	if err != nil {
+		return fmt.Errorf("unable to resolve groups: %v", err)
+	}
+	c.Groups = groups
+	c.GroupRef = ""
+	return nil
+}
+
+// GroupSetRef returns a reference derived from the set of groups, so the
+// same set always has the same reference regardless of order.
+func GroupSetRef(groups []string) string {
+	sorted := slices.Clone(groups)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return "sha256:" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// MemoryGroupStore is a GroupEncoder and GroupResolver which keeps group
+// sets in memory, keyed by GroupSetRef.  It is suitable when the Signer and
+// Verifier share a process, or as a cache in front of a shared directory.
+type MemoryGroupStore struct {
+	sync.RWMutex
+	sets map[string][]string
+}
+
+func NewMemoryGroupStore() *MemoryGroupStore {
+	return &MemoryGroupStore{sets: map[string][]string{}}
+}
+
+func (m *MemoryGroupStore) EncodeGroups(groups []string) (string, error) {
+	ref := GroupSetRef(groups)
+	m.Lock()
+	defer m.Unlock()
+	m.sets[ref] = slices.Clone(groups)
+	return ref, nil
+}
+
+func (m *MemoryGroupStore) ResolveGroups(ref string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	groups, found := m.sets[ref]
+	if !found {
+		return nil, fmt.Errorf("unknown group reference %s", ref)
+	}
+	return slices.Clone(groups), nil
+}
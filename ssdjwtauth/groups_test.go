@@ -0,0 +1,131 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGroupEncoding_roundTrip(t *testing.T) {
+	private, public := testKeyPEMs(t)
+	store := NewMemoryGroupStore()
+	s, err := NewSigner("testkey", private, WithGroupEncoder(store, 3))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, nil, WithGroupResolver(store))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	manyGroups := []string{}
+	for i := 0; i < 50; i++ {
+		manyGroups = append(manyGroups, fmt.Sprintf("cn=group-%d,ou=groups,dc=example,dc=com", i))
+	}
+	tests := []struct {
+		name        string
+		groups      []string
+		wantEncoded bool
+	}{
+		{"below threshold", []string{"a", "b", "c"}, false},
+		{"above threshold", manyGroups, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ssd := testUserClaims
+			ssd.Groups = tt.groups
+			token := signTestToken(t, s, ssd)
+
+			wire := &SsdJwtClaims{}
+			if _, _, err := jwt.NewParser().ParseUnverified(token, wire); err != nil {
+				t.Fatalf("ParseUnverified: %v", err)
+			}
+			if encoded := wire.SSDCLaims.GroupRef != ""; encoded != tt.wantEncoded {
+				t.Errorf("expected encoded=%v, got groupRef %q", tt.wantEncoded, wire.SSDCLaims.GroupRef)
+			}
+			if tt.wantEncoded && len(wire.SSDCLaims.Groups) != 0 {
+				t.Errorf("expected no groups on the wire when encoded")
+			}
+
+			claims, err := v.VerifyToken(token)
+			if err != nil {
+				t.Fatalf("VerifyToken: %v", err)
+			}
+			if !reflect.DeepEqual(claims.SSDCLaims.Groups, tt.groups) || claims.SSDCLaims.GroupRef != "" {
+				t.Errorf("expected groups to be expanded, got %d groups, ref %q", len(claims.SSDCLaims.Groups), claims.SSDCLaims.GroupRef)
+			}
+		})
+	}
+
+	t.Run("no resolver", func(t *testing.T) {
+		plain, err := NewVerifier(map[string][]byte{"testkey": public}, nil)
+		if err != nil {
+			t.Fatalf("NewVerifier: %v", err)
+		}
+		ssd := testUserClaims
+		ssd.Groups = manyGroups
+		if _, err := plain.VerifyToken(signTestToken(t, s, ssd)); err == nil {
+			t.Errorf("expected a verifier without a resolver to reject a group reference")
+		}
+	})
+
+	t.Run("unknown reference", func(t *testing.T) {
+		other, err := NewVerifier(map[string][]byte{"testkey": public}, nil, WithGroupResolver(NewMemoryGroupStore()))
+		if err != nil {
+			t.Fatalf("NewVerifier: %v", err)
+		}
+		ssd := testUserClaims
+		ssd.Groups = manyGroups
+		if _, err := other.VerifyToken(signTestToken(t, s, ssd)); err == nil {
+			t.Errorf("expected an unknown group reference to be rejected")
+		}
+	})
+}
+
+func TestGroupRef_validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  SSDClaims
+		wantErr bool
+	}{
+		{"user with ref", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", GroupRef: "sha256:abc-_"}, false},
+		{"user with both", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", GroupRef: "sha256:abc", Groups: []string{"a"}}, true},
+		{"integration with ref", SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "t", OrgID: "org1", GroupRef: "sha256:abc"}, true},
+		{"bad ref characters", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", GroupRef: "sha256:a/b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSSDClaims(&tt.claims); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSSDClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGroupSetRef(t *testing.T) {
+	a := GroupSetRef([]string{"x", "y", "z"})
+	b := GroupSetRef([]string{"z", "x", "y", "x"})
+	c := GroupSetRef([]string{"x", "y"})
+	if a != b {
+		t.Errorf("expected the same set in a different order to have the same reference")
+	}
+	if a == c {
+		t.Errorf("expected different sets to have different references")
+	}
+}
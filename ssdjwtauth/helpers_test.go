@@ -0,0 +1,118 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	testKeyOnce sync.Once
+	testKey     *rsa.PrivateKey
+)
+
+// testKeyPEMs returns a PEM-encoded private key and its public key,
+// generated once per test run.
+func testKeyPEMs(t testing.TB) (private []byte, public []byte) {
+	t.Helper()
+	testKeyOnce.Do(func() {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic(err)
+		}
+		testKey = k
+	})
+	private = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(testKey),
+	})
+	pubBytes, err := x509.MarshalPKIXPublicKey(&testKey.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal public key: %v", err)
+	}
+	public = pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubBytes,
+	})
+	return private, public
+}
+
+// newTestSignerVerifier returns a Signer and a Verifier which trusts it.
+func newTestSignerVerifier(t testing.TB) (*Signer, *Verifier) {
+	t.Helper()
+	private, public := testKeyPEMs(t)
+	s, err := NewSigner("testkey", private)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, nil)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	return s, v
+}
+
+// signTestToken signs a token valid for one hour carrying ssd.
+func signTestToken(t testing.TB, s *Signer, ssd SSDClaims) string {
+	t.Helper()
+	now := time.Now()
+	token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "test-jti", ssd))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	return token
+}
+
+var testUserClaims = SSDClaims{
+	Type:   SSDTokenTypeUser,
+	UserID: "alice",
+	OrgID:  "org1",
+	Groups: []string{"dev"},
+}
+
+var testServiceClaims = SSDClaims{
+	Type:     SSDTokenTypeService,
+	Service:  "svc",
+	Instance: "svc-1",
+	OrgID:    "org1",
+}
+
+var testInternalClaims = SSDClaims{
+	Type:           SSDTokenTypeInternal,
+	Service:        "scheduler",
+	Authorizations: []string{"artifacts:read"},
+}
+
+var testIntegrationClaims = SSDClaims{
+	Type:   SSDTokenTypeIntegration,
+	TeamID: "team1",
+	OrgID:  "org1",
+}
+
+// nonCanonicalToken changes the unused low bit of the last character of
+// an RS256 token.  The 256 byte signature leaves the low four bits of
+// that character unused, so lenient base64 decoding ignores the change.
+func nonCanonicalToken(token string) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	last := strings.IndexByte(alphabet, token[len(token)-1])
+	return token[:len(token)-1] + string(alphabet[last^1])
+}
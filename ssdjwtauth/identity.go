@@ -0,0 +1,133 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"fmt"
+)
+
+// Identity is a uniform view of the claims of any SSD token type,
+// so handlers do not need to switch on the token type.  The concrete
+// value is one of UserIdentity, ServiceIdentity, InternalIdentity or
+// IntegrationIdentity, which give access to the type-specific claims, or
+// the Identity returned by a type registered with RegisterTokenType.
+type Identity interface {
+	// Type is the token type, such as SSDTokenTypeUser.
+	Type() string
+	// Principal is the user ID, service name or team ID the token was issued to.
+	Principal() string
+	OrgID() string
+	Groups() []string
+	IsAdmin() bool
+	Authorizations() []string
+}
+
+// IdentityFromClaims returns the Identity for verified claims.
+func IdentityFromClaims(s *SsdJwtClaims) (Identity, error) {
+	if s == nil {
+		return nil, fmt.Errorf("no claims")
+	}
+	switch tokenTypeName(s.SSDCLaims.Type) {
+	case tokenTypeName(SSDTokenTypeUser):
+		c, err := SSDUserClaimsFromClaims(s)
+		if err != nil {
+			return nil, err
+		}
+		return UserIdentity{Claims: c}, nil
+	case tokenTypeName(SSDTokenTypeService):
+		c, err := SSDServiceClaimsFromClaims(s)
+		if err != nil {
+			return nil, err
+		}
+		return ServiceIdentity{Claims: c}, nil
+	case tokenTypeName(SSDTokenTypeInternal):
+		c, err := SSDInternalClaimsFromClaims(s)
+		if err != nil {
+			return nil, err
+		}
+		return InternalIdentity{Claims: c}, nil
+	case tokenTypeName(SSDTokenTypeIntegration):
+		c, err := SSDIntegrationClaimsFromClaims(s)
+		if err != nil {
+			return nil, err
+		}
+		return IntegrationIdentity{Claims: c}, nil
+	}
+	if ct, found := registry.customType(s.SSDCLaims.Type); found {
+		if ct.Identity == nil {
+			return nil, fmt.Errorf("token type %s does not provide an identity", s.SSDCLaims.Type)
+		}
+		if err := ValidateSSDClaims(&s.SSDCLaims); err != nil {
+			return nil, err
+		}
+		return ct.Identity(s.SSDCLaims.Custom)
+	}
+	return nil, fmt.Errorf("unknown token type %s", s.SSDCLaims.Type)
+}
+
+// IdentityFromContext returns the Identity for the claims placed in the
+// context by the Verifier's middleware.
+func IdentityFromContext(ctx context.Context) (Identity, error) {
+	claims, found := SSDClaimsFromContext(ctx)
+	if !found {
+		return nil, fmt.Errorf("no SSD claims in context")
+	}
+	return IdentityFromClaims(claims)
+}
+
+type UserIdentity struct {
+	Claims *SSDUserClaims
+}
+
+func (i UserIdentity) Type() string             { return i.Claims.Type }
+func (i UserIdentity) Principal() string        { return i.Claims.UserID }
+func (i UserIdentity) OrgID() string            { return i.Claims.OrgID }
+func (i UserIdentity) Groups() []string         { return i.Claims.Groups }
+func (i UserIdentity) IsAdmin() bool            { return i.Claims.IsAdmin }
+func (i UserIdentity) Authorizations() []string { return []string{} }
+
+type ServiceIdentity struct {
+	Claims *SSDServiceClaims
+}
+
+func (i ServiceIdentity) Type() string             { return i.Claims.Type }
+func (i ServiceIdentity) Principal() string        { return i.Claims.Service }
+func (i ServiceIdentity) OrgID() string            { return i.Claims.OrgID }
+func (i ServiceIdentity) Groups() []string         { return []string{} }
+func (i ServiceIdentity) IsAdmin() bool            { return false }
+func (i ServiceIdentity) Authorizations() []string { return []string{} }
+
+type InternalIdentity struct {
+	Claims *SSDInternalClaims
+}
+
+func (i InternalIdentity) Type() string             { return i.Claims.Type }
+func (i InternalIdentity) Principal() string        { return i.Claims.Service }
+func (i InternalIdentity) OrgID() string            { return "" }
+func (i InternalIdentity) Groups() []string         { return []string{} }
+func (i InternalIdentity) IsAdmin() bool            { return false }
+func (i InternalIdentity) Authorizations() []string { return i.Claims.Authorizations }
+
+type IntegrationIdentity struct {
+	Claims *SSDIntegrationClaims
+}
+
+func (i IntegrationIdentity) Type() string             { return i.Claims.Type }
+func (i IntegrationIdentity) Principal() string        { return i.Claims.TeamID }
+func (i IntegrationIdentity) OrgID() string            { return i.Claims.OrgID }
+func (i IntegrationIdentity) Groups() []string         { return []string{} }
+func (i IntegrationIdentity) IsAdmin() bool            { return false }
+func (i IntegrationIdentity) Authorizations() []string { return []string{} }
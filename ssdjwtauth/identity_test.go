@@ -0,0 +1,112 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestIdentityFromClaims(t *testing.T) {
+	tests := []struct {
+		name           string
+		claims         SSDClaims
+		wantPrincipal  string
+		wantOrgID      string
+		wantGroups     []string
+		wantAdmin      bool
+		wantAuthzs     []string
+		wantErr        bool
+		wantIdentityOf interface{}
+	}{
+		{
+			"user",
+			SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", Groups: []string{"dev"}, IsAdmin: true},
+			"alice", "org1", []string{"dev"}, true, []string{}, false, UserIdentity{},
+		},
+		{
+			"service",
+			SSDClaims{Type: SSDTokenTypeService, Service: "svc", Instance: "i-1", OrgID: "org1"},
+			"svc", "org1", []string{}, false, []string{}, false, ServiceIdentity{},
+		},
+		{
+			"internal",
+			SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", Authorizations: []string{"artifacts:read"}},
+			"svc", "", []string{}, false, []string{"artifacts:read"}, false, InternalIdentity{},
+		},
+		{
+			"integration",
+			SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "team1", OrgID: "org1"},
+			"team1", "org1", []string{}, false, []string{}, false, IntegrationIdentity{},
+		},
+		{
+			"user missing userID",
+			SSDClaims{Type: SSDTokenTypeUser, OrgID: "org1"},
+			"", "", nil, false, nil, true, nil,
+		},
+		{
+			"unknown type",
+			SSDClaims{Type: "robot/v1"},
+			"", "", nil, false, nil, true, nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := IdentityFromClaims(&SsdJwtClaims{SSDCLaims: tt.claims})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IdentityFromClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if reflect.TypeOf(id) != reflect.TypeOf(tt.wantIdentityOf) {
+				t.Errorf("expected identity of type %T, got %T", tt.wantIdentityOf, id)
+			}
+			if id.Type() != tt.claims.Type {
+				t.Errorf("Type() = %s, want %s", id.Type(), tt.claims.Type)
+			}
+			if id.Principal() != tt.wantPrincipal {
+				t.Errorf("Principal() = %s, want %s", id.Principal(), tt.wantPrincipal)
+			}
+			if id.OrgID() != tt.wantOrgID {
+				t.Errorf("OrgID() = %s, want %s", id.OrgID(), tt.wantOrgID)
+			}
+			if !reflect.DeepEqual(id.Groups(), tt.wantGroups) {
+				t.Errorf("Groups() = %v, want %v", id.Groups(), tt.wantGroups)
+			}
+			if id.IsAdmin() != tt.wantAdmin {
+				t.Errorf("IsAdmin() = %v, want %v", id.IsAdmin(), tt.wantAdmin)
+			}
+			if !reflect.DeepEqual(id.Authorizations(), tt.wantAuthzs) {
+				t.Errorf("Authorizations() = %v, want %v", id.Authorizations(), tt.wantAuthzs)
+			}
+		})
+	}
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	if _, err := IdentityFromContext(context.Background()); err == nil {
+		t.Errorf("expected an error with no claims in the context")
+	}
+	claims := &SsdJwtClaims{SSDCLaims: testUserClaims}
+	id, err := IdentityFromContext(contextWithToken(context.Background(), claims, "token"))
+	if err != nil {
+		t.Fatalf("IdentityFromContext: %v", err)
+	}
+	if id.Principal() != "alice" {
+		t.Errorf("expected principal alice, got %s", id.Principal())
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Introspector resolves a reference token into its claims.  The context
+// is that of the request being verified.
+type Introspector interface {
+	Introspect(ctx context.Context, token string) (*SsdJwtClaims, error)
+}
+
+// WithIntrospector lets the Verifier, and so its middleware, accept
+// reference tokens as well as JWTs.
+func WithIntrospector(i Introspector) VerifierOption {
+	return func(v *Verifier) {
+		v.introspector = i
+	}
+}
+
+func (v *Verifier) verifyReferenceToken(ctx context.Context, token string) (*SsdJwtClaims, error) {
+	if v.introspector == nil {
+		return nil, fmt.Errorf("reference tokens are not accepted")
+	}
+	claims, err := v.introspector.Introspect(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	// work on a copy, so the introspector's cached claims are not modified
+	c := claims.clone()
+	// apply the same time, issuer and audience checks as for a JWT, rather
+	// than relying on the introspector to check them
+	if err := jwt.NewValidator(v.parseOptions...).Validate(c); err != nil {
+		return nil, err
+	}
+	if err := UpgradeSSDClaims(&c.SSDCLaims); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSSDClaims, err)
+	}
+	return c, nil
+}
+
+// StoreIntrospector introspects reference tokens directly from a
+// ReferenceStore, for services which share the store with the issuer.
+type StoreIntrospector struct {
+	Store ReferenceStore
+}
+
+func (s StoreIntrospector) Introspect(ctx context.Context, token string) (*SsdJwtClaims, error) {
+	return s.Store.Get(token)
+}
+
+// IntrospectionHandler returns an RFC 7662 token introspection endpoint for
+// reference tokens held in the store.  RFC 7662 requires callers to be
+// authenticated, so install it behind the Verifier's middleware and
+// an authorization check, such as RequireTokenType(SSDTokenTypeInternal).
+func IntrospectionHandler(store ReferenceStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := r.PostForm.Get("token")
+		if token == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Bad Request: token is required"))
+			return
+		}
+
+		response := map[string]any{"active": false}
+		claims, err := store.Get(token)
+		if err == nil {
+			response, err = introspectionResponse(claims)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(response)
+	})
+}
+
+// introspectionResponse returns the registered claims and the SSD claims,
+// along with the RFC 7662 "active", "token_type" and "scope" members.
+func introspectionResponse(claims *SsdJwtClaims) (map[string]any, error) {
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	response := map[string]any{}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return nil, err
+	}
+	response["active"] = true
+	response["token_type"] = "Bearer"
+	if len(claims.SSDCLaims.Authorizations) > 0 {
+		response["scope"] = strings.Join(claims.SSDCLaims.Authorizations, " ")
+	}
+	return response, nil
+}
+
+const (
+	defaultIntrospectionTTL        = 30 * time.Second
+	defaultIntrospectionTimeout    = 10 * time.Second
+	defaultIntrospectionCacheLimit = 10000
+)
+
+var errTokenInactive = errors.New("token is not active")
+
+// IntrospectionClient is an Introspector which calls an RFC 7662 endpoint,
+// such as IntrospectionHandler.  Results are cached for TTL, but never
+// past the token's expiry, so a revoked token may be accepted for up to
+// TTL after revocation.
+type IntrospectionClient struct {
+	// URL of the introspection endpoint.
+	URL string
+	// Token, if set, is sent as a bearer token to authenticate to the endpoint.
+	Token string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Timeout bounds each request to the endpoint, which the Verifier's
+	// middleware waits for, and defaults to 10 seconds.
+	Timeout time.Duration
+	// TTL defaults to 30 seconds.
+	TTL time.Duration
+	// Clock defaults to the system clock.  A Verifier with another clock
+	// (see WithVerifierClock) should be given a client with the same one,
+	// so that results are cached by the same clock as the claims are
+	// checked.
+	Clock Clock
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]introspectionResult
+}
+
+type introspectionResult struct {
+	claims *SsdJwtClaims
+	until  time.Time
+}
+
+func (c *IntrospectionClient) Introspect(ctx context.Context, token string) (*SsdJwtClaims, error) {
+	now := clockOrSystem(c.Clock).Now()
+	key := sha256.Sum256([]byte(token))
+
+	c.mu.Lock()
+	cached, found := c.cache[key]
+	c.mu.Unlock()
+	if found && now.Before(cached.until) {
+		if cached.claims == nil {
+			return nil, errTokenInactive
+		}
+		return cached.claims.clone(), nil
+	}
+
+	claims, err := c.fetch(ctx, now, token)
+	if err != nil && err != errTokenInactive {
+		return nil, err
+	}
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = defaultIntrospectionTTL
+	}
+	result := introspectionResult{claims: claims, until: now.Add(ttl)}
+	if claims != nil && claims.ExpiresAt != nil && claims.ExpiresAt.Before(result.until) {
+		result.until = claims.ExpiresAt.Time
+	}
+	if claims != nil {
+		result.claims = claims.clone()
+	}
+	c.store(key, result, now)
+	return claims, err
+}
+
+func (c *IntrospectionClient) store(key [sha256.Size]byte, result introspectionResult, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = map[[sha256.Size]byte]introspectionResult{}
+	}
+	if len(c.cache) >= defaultIntrospectionCacheLimit {
+		for k, v := range c.cache {
+			if !now.Before(v.until) {
+				delete(c.cache, k)
+			}
+		}
+		// still full of live entries, so make room by dropping arbitrary ones
+		for k := range c.cache {
+			if len(c.cache) < defaultIntrospectionCacheLimit {
+				break
+			}
+			delete(c.cache, k)
+		}
+	}
+	c.cache[key] = result
+}
+
+func (c *IntrospectionClient) fetch(ctx context.Context, now time.Time, token string) (*SsdJwtClaims, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultIntrospectionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("introspection request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection request failed: status %d", resp.StatusCode)
+	}
+
+	active := struct {
+		Active bool `json:"active"`
+	}{}
+	if err := json.Unmarshal(body, &active); err != nil {
+		return nil, fmt.Errorf("unable to parse introspection response: %v", err)
+	}
+	if !active.Active {
+		return nil, errTokenInactive
+	}
+	claims := &SsdJwtClaims{}
+	if err := json.Unmarshal(body, claims); err != nil {
+		return nil, fmt.Errorf("unable to parse introspection response: %v", err)
+	}
+	if claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.Time) {
+		return nil, errTokenInactive
+	}
+	return claims, nil
+}
@@ -16,15 +16,24 @@ package ssdjwtauth
 
 import (
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
-	"log"
+	"encoding/json"
+	"fmt"
+	"log/slog"
 	"math/big"
 )
 
 type JWK struct {
 	E   string `json:"e,omitempty" yaml:"e,omitempty"`
 	N   string `json:"n,omitempty" yaml:"n,omitempty"`
+	CRV string `json:"crv,omitempty" yaml:"crv,omitempty"`
+	X   string `json:"x,omitempty" yaml:"x,omitempty"`
+	Y   string `json:"y,omitempty" yaml:"y,omitempty"`
 	KTY string `json:"kty,omitempty" yaml:"kty,omitempty"`
 	KID string `json:"kid,omitempty" yaml:"kid,omitempty"`
 	ALG string `json:"alg,omitempty" yaml:"alg,omitempty"`
@@ -36,12 +45,16 @@ type JWKWrapper struct {
 }
 
 func JWKFromKeymap(keys map[string]crypto.PublicKey) JWKWrapper {
+	return jwkFromKeymap(keys, slog.Default())
+}
+
+func jwkFromKeymap(keys map[string]crypto.PublicKey, logger *slog.Logger) JWKWrapper {
 	jk := []JWK{}
 
 	for id, pubkey := range keys {
 		rsakey, ok := pubkey.(*rsa.PublicKey)
 		if !ok {
-			log.Printf("Key is not a public key, ignoring")
+			logger.Warn("ignoring key which is not an RSA public key", "kid", id)
 			continue
 		}
 		e64 := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsakey.E)).Bytes())
@@ -60,3 +73,105 @@ func JWKFromKeymap(keys map[string]crypto.PublicKey) JWKWrapper {
 		Keys: jk,
 	}
 }
+
+// JWKFromPublicKey returns the JWK for an RSA or ECDSA public key.
+func JWKFromPublicKey(key crypto.PublicKey) (JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			KTY: "RSA",
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			KTY: "EC",
+			CRV: k.Curve.Params().Name,
+			X:   base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:   base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+// PublicKey returns the RSA or ECDSA public key the JWK describes.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.KTY {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil || len(n) == 0 {
+			return nil, fmt.Errorf("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var point ecdh.Curve
+		switch j.CRV {
+		case "P-256":
+			curve, point = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, point = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, point = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.CRV)
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != size {
+			return nil, fmt.Errorf("invalid EC x coordinate")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil || len(y) != size {
+			return nil, fmt.Errorf("invalid EC y coordinate")
+		}
+		// ecdh rejects points which are not on the curve
+		uncompressed := append(append([]byte{4}, x...), y...)
+		if _, err := point.NewPublicKey(uncompressed); err != nil {
+			return nil, fmt.Errorf("invalid EC public key: %v", err)
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.KTY)
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the JWK, which is
+// computed over the required members only, in lexicographic order.
+func (j JWK) Thumbprint() (string, error) {
+	var members any
+	switch j.KTY {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			KTY string `json:"kty"`
+			N   string `json:"n"`
+		}{j.E, j.KTY, j.N}
+	case "EC":
+		members = struct {
+			CRV string `json:"crv"`
+			KTY string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.CRV, j.KTY, j.X, j.Y}
+	default:
+		return "", fmt.Errorf("unsupported key type %q", j.KTY)
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
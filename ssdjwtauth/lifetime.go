@@ -0,0 +1,118 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"time"
+)
+
+// LifetimePolicy limits how long tokens may be valid, so that a
+// misconfigured issuer cannot produce effectively permanent credentials.
+// Signers refuse to issue tokens which break the policy, and Verifiers
+// reject them.
+type LifetimePolicy struct {
+	// MaxLifetime maps token type names, without the version (e.g. "user"),
+	// to the longest time allowed between iat and exp.
+	MaxLifetime map[string]time.Duration
+	// Default applies to types not in MaxLifetime.  Zero means no limit.
+	Default time.Duration
+}
+
+// WithSignerLifetimePolicy makes the Signer refuse to issue tokens which
+// break the policy.
+func WithSignerLifetimePolicy(p LifetimePolicy) SignerOption {
+	return func(s *Signer) {
+		s.lifetime = &p
+	}
+}
+
+// WithVerifierLifetimePolicy makes the Verifier reject tokens which break
+// the policy.
+func WithVerifierLifetimePolicy(p LifetimePolicy) VerifierOption {
+	return func(v *Verifier) {
+		v.lifetime = &p
+	}
+}
+
+func (p *LifetimePolicy) maxLifetime(tokenType string) time.Duration {
+	name, _, err := ParseTokenType(tokenType)
+	if err != nil {
+		return p.Default
+	}
+	if limit, found := p.MaxLifetime[name]; found {
+		return limit
+	}
+	return p.Default
+}
+
+// Check returns an error if the claims' lifetime exceeds the maximum for
+// their type, or if their times are inconsistent.  When a maximum applies,
+// exp and one of iat or nbf are required.
+func (p *LifetimePolicy) Check(claims *SsdJwtClaims) error {
+	if err := checkTimeOrder(claims); err != nil {
+		return err
+	}
+	limit := p.maxLifetime(claims.SSDCLaims.Type)
+	if limit <= 0 {
+		return nil
+	}
+	start := claims.IssuedAt
+	if start == nil {
+		start = claims.NotBefore
+	}
+	if start == nil || claims.ExpiresAt == nil {
+		return fmt.Errorf("token lifetime cannot be determined without exp and iat or nbf")
+	}
+	if lifetime := claims.ExpiresAt.Sub(start.Time); lifetime > limit {
+		return fmt.Errorf("token lifetime %v exceeds the maximum of %v for %s tokens", lifetime, limit, claims.SSDCLaims.Type)
+	}
+	return nil
+}
+
+// checkTimeOrder requires nbf to fall between iat, allowing for clock skew,
+// and exp.  An nbf well before iat backdates the token, extending its
+// useful life beyond what its lifetime suggests.
+func checkTimeOrder(claims *SsdJwtClaims) error {
+	if claims.NotBefore == nil {
+		return nil
+	}
+	if claims.IssuedAt != nil && claims.NotBefore.Before(claims.IssuedAt.Add(-tokenLeeway)) {
+		return fmt.Errorf("token nbf is more than %v before iat", tokenLeeway)
+	}
+	if claims.ExpiresAt != nil && !claims.NotBefore.Before(claims.ExpiresAt.Time) {
+		return fmt.Errorf("token nbf is not before exp")
+	}
+	return nil
+}
+
+// checkLifetime applies the policy, if any, to verified claims.
+func (v *Verifier) checkLifetime(claims *SsdJwtClaims) error {
+	if v.lifetime == nil {
+		return nil
+	}
+	if err := v.lifetime.Check(claims); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidSSDClaims, err)
+	}
+	return nil
+}
+
+// checkLifetime applies the policy, if any, to claims about to be issued.
+func (s *Signer) checkLifetime(claims *SsdJwtClaims) error {
+	if s.lifetime == nil {
+		return nil
+	}
+	return s.lifetime.Check(claims)
+}
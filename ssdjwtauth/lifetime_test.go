@@ -0,0 +1,101 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLifetimePolicyCheck(t *testing.T) {
+	policy := LifetimePolicy{
+		MaxLifetime: map[string]time.Duration{"user": 12 * time.Hour},
+		Default:     time.Hour,
+	}
+	now := time.Unix(1700000000, 0)
+	at := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(now.Add(d)) }
+	service := SSDClaims{Type: SSDTokenTypeService, OrgID: "org1", Service: "svc", Instance: "svc-1"}
+
+	tests := []struct {
+		name    string
+		ssd     SSDClaims
+		iat     *jwt.NumericDate
+		nbf     *jwt.NumericDate
+		exp     *jwt.NumericDate
+		wantErr bool
+	}{
+		{"user within limit", testUserClaims, at(0), at(0), at(12 * time.Hour), false},
+		{"user over limit", testUserClaims, at(0), at(0), at(13 * time.Hour), true},
+		{"ten year user token", testUserClaims, at(0), at(0), at(10 * 365 * 24 * time.Hour), true},
+		{"default applies to service", service, at(0), at(0), at(2 * time.Hour), true},
+		{"service within default", service, at(0), at(0), at(time.Hour), false},
+		{"nbf only", testUserClaims, nil, at(0), at(time.Hour), false},
+		{"no start time", testUserClaims, nil, nil, at(time.Hour), true},
+		{"no expiry", testUserClaims, at(0), nil, nil, true},
+		{"nbf long before iat", testUserClaims, at(0), at(-24 * time.Hour), at(time.Hour), true},
+		{"nbf slightly before iat", testUserClaims, at(0), at(-time.Minute), at(time.Hour), false},
+		{"nbf after exp", testUserClaims, at(0), at(2 * time.Hour), at(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &SsdJwtClaims{
+				RegisteredClaims: jwt.RegisteredClaims{IssuedAt: tt.iat, NotBefore: tt.nbf, ExpiresAt: tt.exp},
+				SSDCLaims:        tt.ssd,
+			}
+			if err := policy.Check(claims); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLifetimePolicyEnforcement(t *testing.T) {
+	policy := LifetimePolicy{MaxLifetime: map[string]time.Duration{"user": time.Hour}}
+	s, v := newTestSignerVerifier(t)
+	now := time.Now()
+	long := s.MakeClaims(now, now.Add(24*time.Hour), "long", testUserClaims)
+	if long.IssuedAt == nil || !long.IssuedAt.Equal(long.NotBefore.Time) {
+		t.Errorf("MakeClaims did not set iat")
+	}
+
+	longToken, err := s.SignToken(long)
+	if err != nil {
+		t.Fatalf("SignToken without policy: %v", err)
+	}
+	WithSignerLifetimePolicy(policy)(s)
+	if _, err := s.SignToken(long); err == nil {
+		t.Errorf("expected SignToken to reject a token longer than the policy allows")
+	}
+	if _, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "ok", testUserClaims)); err != nil {
+		t.Errorf("SignToken: %v", err)
+	}
+
+	if _, err := v.VerifyToken(longToken); err != nil {
+		t.Fatalf("VerifyToken without policy: %v", err)
+	}
+	WithVerifierLifetimePolicy(policy)(v)
+	_, err = v.VerifyToken(longToken)
+	if err == nil {
+		t.Fatalf("expected VerifyToken to reject a token longer than the policy allows")
+	}
+	if reason := rejectReason(err); reason != RejectClaims {
+		t.Errorf("reject reason = %s, want %s", reason, RejectClaims)
+	}
+	if report := v.ExplainToken(longToken); report.Valid {
+		t.Errorf("ExplainToken reported the long token as valid")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"log/slog"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// maxLoggedKeyID bounds the length of an unverified key id in logs.
+const maxLoggedKeyID = 64
+
+// WithVerifierLogger sets the logger for the Verifier and its middleware.
+// Token contents are never logged.  By default, slog.Default() is used.
+func WithVerifierLogger(l *slog.Logger) VerifierOption {
+	return func(v *Verifier) {
+		v.log = l
+	}
+}
+
+// WithSignerLogger sets the logger for the Signer.  By default,
+// slog.Default() is used.
+func WithSignerLogger(l *slog.Logger) SignerOption {
+	return func(s *Signer) {
+		s.log = l
+	}
+}
+
+// WithPolicyLogger sets the logger used while maintaining a policy file.
+// By default, slog.Default() is used.
+func WithPolicyLogger(l *slog.Logger) PolicyOption {
+	return func(p *Policy) {
+		p.log = l
+	}
+}
+
+func (v *Verifier) logger() *slog.Logger {
+	if v.log == nil {
+		return slog.Default()
+	}
+	return v.log
+}
+
+func (s *Signer) logger() *slog.Logger {
+	if s.log == nil {
+		return slog.Default()
+	}
+	return s.log
+}
+
+func (p *Policy) logger() *slog.Logger {
+	if p.log == nil {
+		return slog.Default()
+	}
+	return p.log
+}
+
+// claimsAttrs are the fields logged to identify verified claims.
+func claimsAttrs(claims *SsdJwtClaims) []any {
+	return []any{
+		slog.String("type", claims.SSDCLaims.Type),
+		slog.String("orgID", claims.SSDCLaims.OrgID),
+		slog.String("jti", claims.ID),
+	}
+}
+
+// unverifiedKeyID returns the kid from the token header, for logging a
+// token which failed verification.
+func unverifiedKeyID(tokenString string) string {
+	if IsReferenceToken(tokenString) {
+		return ""
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	kid, _ := token.Header["kid"].(string)
+	return truncateKeyID(kid)
+}
+
+// truncateKeyID shortens an unverified key id to at most maxLoggedKeyID
+// bytes, without splitting a UTF-8 sequence.
+func truncateKeyID(kid string) string {
+	if len(kid) <= maxLoggedKeyID {
+		return kid
+	}
+	n := maxLoggedKeyID
+	for n > 0 && !utf8.RuneStart(kid[n]) {
+		n--
+	}
+	return kid[:n] + "..."
+}
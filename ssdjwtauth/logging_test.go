@@ -0,0 +1,68 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestVerifierLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	s, v := newTestSignerVerifier(t)
+	WithVerifierLogger(logger)(v)
+	WithSignerLogger(logger)(s)
+
+	token := signTestToken(t, s, testUserClaims)
+	tampered := token[:len(token)-4] + "AAAA"
+	v.VerifyToken(token)
+	v.VerifyToken(tampered)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3:\n%s", len(lines), buf.String())
+	}
+	tests := []struct {
+		msg  string
+		want map[string]any
+	}{
+		{"token issued", map[string]any{"type": SSDTokenTypeUser, "orgID": "org1", "jti": "test-jti"}},
+		{"token verified", map[string]any{"type": SSDTokenTypeUser, "orgID": "org1"}},
+		{"token rejected", map[string]any{"reason": string(RejectSignature), "kid": "testkey"}},
+	}
+	for i, tt := range tests {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if entry["msg"] != tt.msg {
+			t.Errorf("line %d: msg = %v, want %s", i, entry["msg"], tt.msg)
+		}
+		for k, want := range tt.want {
+			if entry[k] != want {
+				t.Errorf("line %d: %s = %v, want %v", i, k, entry[k], want)
+			}
+		}
+	}
+
+	signature := token[strings.LastIndex(token, ".")+1:]
+	if strings.Contains(buf.String(), signature[:16]) || strings.Contains(buf.String(), tampered[:32]) {
+		t.Errorf("token contents were logged:\n%s", buf.String())
+	}
+}
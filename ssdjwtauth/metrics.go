@@ -0,0 +1,123 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// errInvalidSSDClaims wraps errors from validating the SSD claims of an
+// otherwise valid token.
+var errInvalidSSDClaims = errors.New("invalid SSD claims")
+
+// RejectReason classifies why a token was rejected, for metrics and logs.
+type RejectReason string
+
+const (
+	RejectMalformed   RejectReason = "malformed"
+	RejectUnknownKey  RejectReason = "unknown_key"
+	RejectSignature   RejectReason = "signature"
+	RejectExpired     RejectReason = "expired"
+	RejectNotYetValid RejectReason = "not_yet_valid"
+	RejectClaims      RejectReason = "claims"
+	RejectInactive    RejectReason = "inactive"
+	RejectReplayed    RejectReason = "replayed"
+	RejectDPoP        RejectReason = "dpop"
+	RejectCertificate RejectReason = "certificate"
+	RejectOther       RejectReason = "other"
+)
+
+// Metrics receives measurements from Signers and Verifiers.  Token types
+// passed to it have been validated against the registered types, so they
+// are safe to use as metric labels; an empty type means the token was
+// rejected before its claims could be trusted.
+type Metrics interface {
+	// TokenVerified is called when VerifyToken, VerifyRequest or the
+	// middleware accepts a token, after every check has passed.
+	TokenVerified(tokenType string, duration time.Duration)
+	// TokenRejected is called when VerifyToken, VerifyRequest or the
+	// middleware rejects a token.
+	TokenRejected(reason RejectReason, tokenType string)
+	// TokenSigned is called when a Signer issues a token.
+	TokenSigned(tokenType string, duration time.Duration)
+	// KeysReloaded is called after a Verifier reloads its key files.
+	KeysReloaded(err error)
+	// TokenCacheLookup is called for each lookup in the verified-token cache.
+	TokenCacheLookup(hit bool)
+}
+
+// NoopMetrics discards all measurements.  It is used when no Metrics are
+// configured.
+type NoopMetrics struct{}
+
+func (NoopMetrics) TokenVerified(string, time.Duration) {}
+func (NoopMetrics) TokenRejected(RejectReason, string)  {}
+func (NoopMetrics) TokenSigned(string, time.Duration)   {}
+func (NoopMetrics) KeysReloaded(error)                  {}
+func (NoopMetrics) TokenCacheLookup(bool)               {}
+
+// WithVerifierMetrics sends the Verifier's measurements to m.
+func WithVerifierMetrics(m Metrics) VerifierOption {
+	return func(v *Verifier) {
+		v.metrics = m
+	}
+}
+
+// WithSignerMetrics sends the Signer's measurements to m.
+func WithSignerMetrics(m Metrics) SignerOption {
+	return func(s *Signer) {
+		s.metrics = m
+	}
+}
+
+func (v *Verifier) getMetrics() Metrics {
+	if v.metrics == nil {
+		return NoopMetrics{}
+	}
+	return v.metrics
+}
+
+func (s *Signer) getMetrics() Metrics {
+	if s.metrics == nil {
+		return NoopMetrics{}
+	}
+	return s.metrics
+}
+
+// rejectReason classifies an error returned while verifying a token.
+func rejectReason(err error) RejectReason {
+	switch {
+	case errors.Is(err, ErrTokenReplayed):
+		return RejectReplayed
+	case errors.Is(err, errTokenInactive), errors.Is(err, ErrReferenceNotFound):
+		return RejectInactive
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return RejectMalformed
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return RejectUnknownKey
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return RejectSignature
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return RejectExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return RejectNotYetValid
+	case errors.Is(err, jwt.ErrTokenInvalidClaims), errors.Is(err, errInvalidSSDClaims):
+		return RejectClaims
+	}
+	return RejectOther
+}
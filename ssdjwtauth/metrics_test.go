@@ -0,0 +1,177 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records events as strings.
+type recordingMetrics struct {
+	sync.Mutex
+	events []string
+}
+
+func (m *recordingMetrics) record(format string, args ...any) {
+	m.Lock()
+	defer m.Unlock()
+	m.events = append(m.events, fmt.Sprintf(format, args...))
+}
+
+func (m *recordingMetrics) take() []string {
+	m.Lock()
+	defer m.Unlock()
+	events := m.events
+	m.events = nil
+	return events
+}
+
+func (m *recordingMetrics) TokenVerified(tokenType string, _ time.Duration) {
+	m.record("verified %s", tokenType)
+}
+
+func (m *recordingMetrics) TokenRejected(reason RejectReason, tokenType string) {
+	m.record("rejected %s %s", reason, tokenType)
+}
+
+func (m *recordingMetrics) TokenSigned(tokenType string, _ time.Duration) {
+	m.record("signed %s", tokenType)
+}
+
+func (m *recordingMetrics) KeysReloaded(err error) {
+	m.record("reloaded %v", err == nil)
+}
+
+func (m *recordingMetrics) TokenCacheLookup(hit bool) {
+	m.record("cache hit %v", hit)
+}
+
+func TestVerifierMetrics(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	m := &recordingMetrics{}
+	WithVerifierMetrics(m)(v)
+	WithSignerMetrics(m)(s)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	forger, err := NewSigner("testkey", pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(otherKey),
+	}))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	unknown, err := NewSigner("otherkey", pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(testKey),
+	}))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+
+	now := time.Now()
+	valid := signTestToken(t, s, testUserClaims)
+	expired, err := s.SignToken(s.MakeClaims(now.Add(-2*time.Hour), now.Add(-time.Hour), "expired", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	_, jwk := newDPoPKey(t)
+	dpopBound := signBoundToken(t, s, jwk)
+	certBound, err := s.SignCertificateBoundToken(s.MakeClaims(now, now.Add(time.Hour), "bound", testUserClaims), newTestCertificate(t, "svc"))
+	if err != nil {
+		t.Fatalf("SignCertificateBoundToken: %v", err)
+	}
+	if got, want := m.take(), []string{"signed user/v1", "signed user/v1", "signed user/v1", "signed user/v1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("signing events = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"valid", valid, "verified user/v1"},
+		{"malformed", "not-a-token", "rejected malformed "},
+		{"expired", expired, "rejected expired "},
+		{"forged", signTestToken(t, forger, testUserClaims), "rejected signature "},
+		{"unknown key", signTestToken(t, unknown, testUserClaims), "rejected unknown_key "},
+		{"reference without introspector", ReferenceTokenPrefix + "abc", "rejected other "},
+		{"dpop bound without request", dpopBound, "rejected dpop user/v1"},
+		{"certificate bound without request", certBound, "rejected certificate user/v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v.VerifyToken(tt.token)
+			if got := m.take(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("events = %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+
+	WithTokenCache(10)(v)
+	v.VerifyToken(valid)
+	v.VerifyToken(valid)
+	want := []string{"cache hit false", "verified user/v1", "cache hit true", "verified user/v1"}
+	if got := m.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("cache events = %v, want %v", got, want)
+	}
+
+	once := testUserClaims
+	once.Once = true
+	WithReplayCache(NewMemoryReplayCache(0))(v)
+	onceToken := signTestToken(t, s, once)
+	m.take()
+	v.VerifyToken(onceToken)
+	v.VerifyToken(onceToken)
+	want = []string{"cache hit false", "verified user/v1", "cache hit true", "rejected replayed user/v1"}
+	if got := m.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("replay events = %v, want %v", got, want)
+	}
+}
+
+func TestKeyReloadMetrics(t *testing.T) {
+	_, public := testKeyPEMs(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "testkey"), public, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	m := &recordingMetrics{}
+	v, err := NewVerifier(nil, nil, WithVerifierMetrics(m))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	if err := v.reloadKeyFiles(context.Background(), dir); err != nil {
+		t.Fatalf("reloadKeyFiles: %v", err)
+	}
+	if err := v.reloadKeyFiles(context.Background(), filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("expected error reloading a missing directory")
+	}
+	if got, want := m.take(), []string{"reloaded true", "reloaded false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
@@ -18,6 +18,8 @@ import (
 	"context"
 	"net/http"
 	"strings"
+
+	"go.opentelemetry.io/otel/codes"
 )
 
 type ssdContextKeyType int
@@ -25,19 +27,73 @@ type ssdContextKeyType int
 var (
 	ssdContextKey      ssdContextKeyType = 0
 	ssdTokenContextKey ssdContextKeyType = 1
+	ssdAuditContextKey ssdContextKeyType = 2
 )
 
-func (v *Verifier) MiddlewareFunc() func(next http.Handler) http.Handler {
+type middlewareConfig struct {
+	cookieName string
+}
+
+// MiddlewareOption configures the middleware returned by MiddlewareFunc.
+type MiddlewareOption func(*middlewareConfig)
+
+// WithTokenCookie makes the middleware fall back to reading the token from
+// the named cookie (see SetTokenCookie) when no token is present in the
+// headers.  This is used by the UI path.
+func WithTokenCookie(name string) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		if name == "" {
+			name = DefaultCookieName
+		}
+		c.cookieName = name
+	}
+}
+
+func (v *Verifier) MiddlewareFunc(opts ...MiddlewareOption) func(next http.Handler) http.Handler {
+	config := &middlewareConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenStr := TokenFromHeaders(r)
-			claims, err := v.VerifyToken(tokenStr)
+			ctx, span := v.startSpan(r.Context(), "ssdjwtauth.VerifyRequest")
+			tokenStr := config.tokenFromRequest(r)
+			// verify within the span, but the handler runs after it ends
+			// so gets the original context
+			claims, reason, err := v.verify(r.WithContext(ctx), tokenStr)
 			if err != nil {
+				// a rejected token is audited if its claims were verified
+				// before the rejection, so can be trusted to be privileged
+				if claims != nil {
+					if auditErr := v.auditRequest(r, claims, reason); auditErr != nil {
+						v.logger().ErrorContext(ctx, "unable to audit request", "error", auditErr)
+						span.SetStatus(codes.Error, "audit failed")
+						span.End()
+						w.WriteHeader(http.StatusInternalServerError)
+						w.Write([]byte("Internal Server Error"))
+						return
+					}
+				}
+				recordRejection(span, reason)
+				span.End()
+				if reason == RejectDPoP {
+					w.Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+				}
 				w.WriteHeader(http.StatusUnauthorized)
 				w.Write([]byte("Unauthorized"))
 				return
 			}
+			v.recordClaims(span, claims)
+			span.End()
 			r = r.WithContext(contextWithToken(r.Context(), claims, tokenStr))
+			if audit := v.newPendingAudit(r, claims); audit != nil {
+				r = r.WithContext(context.WithValue(r.Context(), ssdAuditContextKey, audit))
+				aw := &auditResponseWriter{ResponseWriter: w, audit: audit, log: v.logger()}
+				next.ServeHTTP(aw, r)
+				// for a handler which wrote no response
+				aw.start()
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -59,6 +115,14 @@ func SSDTokenFromContext(ctx context.Context) (string, bool) {
 	return v, ok
 }
 
+func (c *middlewareConfig) tokenFromRequest(r *http.Request) string {
+	tokenStr := TokenFromHeaders(r)
+	if tokenStr == "" && c.cookieName != "" {
+		tokenStr = TokenFromCookies(r, c.cookieName)
+	}
+	return tokenStr
+}
+
 func TokenFromHeaders(r *http.Request) string {
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
@@ -67,9 +131,23 @@ func TokenFromHeaders(r *http.Request) string {
 	if auth == "" {
 		return ""
 	}
+	if token, found := strings.CutPrefix(auth, dpopScheme+" "); found {
+		return token
+	}
 	splitToken := strings.Split(auth, "Bearer ")
 	if len(splitToken) < 2 {
 		return "Header does not contain TOKEN"
 	}
 	return splitToken[1]
 }
+
+// authScheme returns the scheme of the header TokenFromHeaders reads the
+// token from, or "" if there is none.
+func authScheme(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		auth = r.Header.Get("X-OpsMx-Auth")
+	}
+	scheme, _, _ := strings.Cut(auth, " ")
+	return scheme
+}
@@ -83,10 +83,10 @@ func Test_tokenFromHeaders(t *testing.T) {
 
 func Test_contextWithToken(t *testing.T) {
 	claims := &SsdJwtClaims{
-		jwt.RegisteredClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer: "testissuer",
 		},
-		SSDClaims{},
+		SSDCLaims: SSDClaims{},
 	}
 	token := "token goes here"
 	ctx := contextWithToken(context.Background(), claims, token)
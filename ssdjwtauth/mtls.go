@@ -0,0 +1,69 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+)
+
+// CertificateThumbprint returns the base64url SHA-256 hash of the DER
+// certificate, as used in the x5t#S256 confirmation claim (RFC 8705).
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// BindCertificate makes the claims sender-constrained to the mutual TLS
+// client presenting cert, by setting the cnf.x5t#S256 claim.
+func (c *SsdJwtClaims) BindCertificate(cert *x509.Certificate) {
+	if c.Confirmation == nil {
+		c.Confirmation = &Confirmation{}
+	}
+	c.Confirmation.X5TS256 = CertificateThumbprint(cert)
+}
+
+// SignCertificateBoundToken signs the claims after binding them to the
+// client certificate of the service which will present the token.
+func (s *Signer) SignCertificateBoundToken(claims SsdJwtClaims, cert *x509.Certificate) (string, error) {
+	if cert == nil || len(cert.Raw) == 0 {
+		return "", fmt.Errorf("a certificate is required to bind the token")
+	}
+	claims.BindCertificate(cert)
+	return s.SignToken(claims)
+}
+
+// checkCertificateBinding rejects a certificate-bound token which was not
+// presented on a mutual TLS connection using the bound certificate.
+func checkCertificateBinding(r *http.Request, claims *SsdJwtClaims) error {
+	if claims.Confirmation == nil || claims.Confirmation.X5TS256 == "" {
+		return nil
+	}
+	if r == nil {
+		return fmt.Errorf("certificate-bound token must be verified with its request")
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("certificate-bound token requires a client certificate")
+	}
+	got := CertificateThumbprint(r.TLS.PeerCertificates[0])
+	if subtle.ConstantTimeCompare([]byte(got), []byte(claims.Confirmation.X5TS256)) != 1 {
+		return fmt.Errorf("client certificate does not match the token")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return cert
+}
+
+func TestCertificateBoundToken(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	bound := newTestCertificate(t, "svc")
+	other := newTestCertificate(t, "other")
+
+	now := time.Now()
+	service := SSDClaims{Type: SSDTokenTypeService, OrgID: "org1", Service: "svc", Instance: "svc-1"}
+	token, err := s.SignCertificateBoundToken(s.MakeClaims(now, now.Add(time.Hour), "test-jti", service), bound)
+	if err != nil {
+		t.Fatalf("SignCertificateBoundToken: %v", err)
+	}
+	if _, err := s.SignCertificateBoundToken(s.MakeClaims(now, now.Add(time.Hour), "test-jti", service), nil); err == nil {
+		t.Errorf("expected error binding to a nil certificate")
+	}
+
+	if _, err := v.VerifyToken(token); err == nil {
+		t.Errorf("expected VerifyToken to reject a certificate-bound token")
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{bound}}
+	claims, err := v.VerifyRequest(r, token)
+	if err != nil {
+		t.Fatalf("VerifyRequest: %v", err)
+	}
+	if claims.Confirmation == nil || claims.Confirmation.X5TS256 != CertificateThumbprint(bound) {
+		t.Errorf("cnf = %+v, want x5t#S256 of bound certificate", claims.Confirmation)
+	}
+
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	tests := []struct {
+		name  string
+		token string
+		tls   *tls.ConnectionState
+		want  int
+	}{
+		{"bound certificate", token, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{bound}}, http.StatusOK},
+		{"other certificate", token, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{other}}, http.StatusUnauthorized},
+		{"no client certificate", token, &tls.ConnectionState{}, http.StatusUnauthorized},
+		{"no tls", token, nil, http.StatusUnauthorized},
+		{"unbound token", signTestToken(t, s, service), nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			r.TLS = tt.tls
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertificateCheckedBeforeReplay(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithReplayCache(NewMemoryReplayCache(0))(v)
+	bound := newTestCertificate(t, "svc")
+	other := newTestCertificate(t, "other")
+
+	now := time.Now()
+	service := SSDClaims{Type: SSDTokenTypeService, OrgID: "org1", Service: "svc", Instance: "svc-1", Once: true}
+	token, err := s.SignCertificateBoundToken(s.MakeClaims(now, now.Add(time.Hour), "once-jti", service), bound)
+	if err != nil {
+		t.Fatalf("SignCertificateBoundToken: %v", err)
+	}
+
+	request := func(cert *x509.Certificate) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		return r
+	}
+	if _, err := v.VerifyRequest(request(other), token); err == nil {
+		t.Fatalf("expected the other certificate to be rejected")
+	}
+	if _, err := v.VerifyRequest(request(bound), token); err != nil {
+		t.Errorf("token was consumed by a request with the wrong certificate: %v", err)
+	}
+	if _, err := v.VerifyRequest(request(bound), token); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("expected the second use to be rejected as replayed, got %v", err)
+	}
+}
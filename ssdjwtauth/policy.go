@@ -0,0 +1,348 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PolicyEffectAllow = "allow"
+	PolicyEffectDeny  = "deny"
+)
+
+// PolicyDocument is the on-disk form of a Policy, in YAML or JSON.
+//
+// Rules are evaluated in order, and the first rule which matches the
+// resource, action and claims decides the outcome.  If no rule matches,
+// Default is used, which is "deny" unless set to "allow".
+//
+//	default: deny
+//	rules:
+//	  - name: admins
+//	    effect: allow
+//	    isAdmin: true
+//	  - name: deployers
+//	    effect: allow
+//	    resources: ["/api/v1/deployments/**"]
+//	    actions: ["GET", "POST"]
+//	    groups: ["deployers"]
+type PolicyDocument struct {
+	Default string       `json:"default,omitempty" yaml:"default,omitempty"`
+	Rules   []PolicyRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// PolicyRule matches a request when every field which is set matches.
+// Fields which are empty are not considered.
+//
+// Resources are '/' separated patterns, where '*' matches within a single
+// segment and a '**' segment matches any number of segments.  For HTTP
+// requests the resource is the URL path and the action is the method.
+type PolicyRule struct {
+	Name      string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Effect    string   `json:"effect" yaml:"effect"`
+	Resources []string `json:"resources,omitempty" yaml:"resources,omitempty"`
+	Actions   []string `json:"actions,omitempty" yaml:"actions,omitempty"`
+
+	// The token type must be one of these, in any version.
+	Types []string `json:"types,omitempty" yaml:"types,omitempty"`
+	// If set, the token's isAdmin must have this value.
+	IsAdmin *bool `json:"isAdmin,omitempty" yaml:"isAdmin,omitempty"`
+	// The token must be in at least one of these groups.
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+	// The token must hold all of these authorizations, which must be
+	// scopes (see Scope).
+	Authorizations []string `json:"authorizations,omitempty" yaml:"authorizations,omitempty"`
+	// The token's orgID, userID or service must be one of these.
+	OrgIDs   []string `json:"orgIDs,omitempty" yaml:"orgIDs,omitempty"`
+	UserIDs  []string `json:"userIDs,omitempty" yaml:"userIDs,omitempty"`
+	Services []string `json:"services,omitempty" yaml:"services,omitempty"`
+}
+
+// Decision is the result of evaluating a Policy.
+type Decision struct {
+	Allowed bool
+	// Rule is the name of the rule which matched, or empty if the default applied.
+	Rule   string
+	Reason string
+}
+
+// Policy makes access decisions from SSDClaims.  It is safe for concurrent
+// use, and may be updated while in use.
+type Policy struct {
+	sync.Mutex
+	doc   PolicyDocument
+	log   *slog.Logger
+	clock Clock
+}
+
+// PolicyOption configures optional Policy behavior.
+type PolicyOption func(*Policy)
+
+// NewPolicy parses a YAML or JSON policy document.
+func NewPolicy(data []byte, opts ...PolicyOption) (*Policy, error) {
+	p := &Policy{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if err := p.Update(data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadPolicyFile reads a policy document from a file.
+func LoadPolicyFile(filename string, opts ...PolicyOption) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicy(data, opts...)
+}
+
+// Update replaces the rules in the policy.  If the document is invalid,
+// an error is returned and the existing rules remain in place.
+func (p *Policy) Update(data []byte) error {
+	doc, err := parsePolicyDocument(data)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.doc = doc
+	return nil
+}
+
+func parsePolicyDocument(data []byte) (PolicyDocument, error) {
+	doc := PolicyDocument{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && err != io.EOF {
+		return doc, fmt.Errorf("unable to parse policy: %v", err)
+	}
+	if doc.Default == "" {
+		doc.Default = PolicyEffectDeny
+	}
+	if doc.Default != PolicyEffectAllow && doc.Default != PolicyEffectDeny {
+		return doc, fmt.Errorf("policy default must be %s or %s, not %q", PolicyEffectAllow, PolicyEffectDeny, doc.Default)
+	}
+	for i, rule := range doc.Rules {
+		if rule.Effect != PolicyEffectAllow && rule.Effect != PolicyEffectDeny {
+			return doc, fmt.Errorf("policy rule %d (%s): effect must be %s or %s, not %q", i, rule.Name, PolicyEffectAllow, PolicyEffectDeny, rule.Effect)
+		}
+		// a malformed pattern would never match, so a deny rule using one
+		// would fail open
+		for _, pattern := range rule.Resources {
+			if err := checkResourcePattern(pattern); err != nil {
+				return doc, fmt.Errorf("policy rule %d (%s): %v", i, rule.Name, err)
+			}
+		}
+		for _, a := range rule.Authorizations {
+			if _, err := ParseScope(a); err != nil {
+				return doc, fmt.Errorf("policy rule %d (%s): %v", i, rule.Name, err)
+			}
+		}
+		if rule.Name == "" {
+			doc.Rules[i].Name = fmt.Sprintf("rule-%d", i)
+		}
+	}
+	return doc, nil
+}
+
+// MaintainFile loads the policy from a file, and then reloads it in the
+// background whenever the file changes, until the context is cancelled.
+func (p *Policy) MaintainFile(ctx context.Context, filename string) error {
+	return p.maintainFile(ctx, filename, time.Second*60)
+}
+
+func (p *Policy) maintainFile(ctx context.Context, filename string, interval time.Duration) error {
+	modTime, err := p.reloadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// beyond here we cannot do more than log errors
+	go func() {
+		t := clockOrSystem(p.clock).NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C():
+				info, err := os.Stat(filename)
+				if err != nil {
+					p.logger().Error("unable to check policy file", "file", filename, "error", err)
+					continue
+				}
+				if info.ModTime().Equal(modTime) {
+					continue
+				}
+				newModTime, err := p.reloadFile(filename)
+				if err != nil {
+					p.logger().Error("unable to reload policy", "file", filename, "error", err)
+					continue
+				}
+				modTime = newModTime
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *Policy) reloadFile(filename string) (time.Time, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), p.Update(data)
+}
+
+// Decide evaluates the policy for the claims performing action on resource.
+func (p *Policy) Decide(claims *SsdJwtClaims, resource string, action string) Decision {
+	if claims == nil {
+		return Decision{Reason: "no claims"}
+	}
+	p.Lock()
+	doc := p.doc
+	p.Unlock()
+
+	for _, rule := range doc.Rules {
+		if rule.matches(&claims.SSDCLaims, resource, action) {
+			return Decision{
+				Allowed: rule.Effect == PolicyEffectAllow,
+				Rule:    rule.Name,
+				Reason:  fmt.Sprintf("rule %s: %s", rule.Name, rule.Effect),
+			}
+		}
+	}
+	return Decision{
+		Allowed: doc.Default == PolicyEffectAllow,
+		Reason:  fmt.Sprintf("no rule matched, default: %s", doc.Default),
+	}
+}
+
+// MiddlewareFunc returns middleware which applies the policy to each request,
+// using the cleaned URL path as the resource and the method as the action.
+// It must be installed after the Verifier's middleware.
+func (p *Policy) MiddlewareFunc() func(next http.Handler) http.Handler {
+	return Require(func(r *http.Request, claims *SsdJwtClaims) error {
+		d := p.Decide(claims, path.Clean("/"+r.URL.Path), r.Method)
+		if !d.Allowed {
+			return fmt.Errorf("%s", d.Reason)
+		}
+		return nil
+	})
+}
+
+func (rule PolicyRule) matches(c *SSDClaims, resource string, action string) bool {
+	if len(rule.Resources) > 0 && !slices.ContainsFunc(rule.Resources, func(pattern string) bool {
+		return matchResourcePattern(pattern, resource)
+	}) {
+		return false
+	}
+	if len(rule.Actions) > 0 && !slices.ContainsFunc(rule.Actions, func(a string) bool {
+		return a == "*" || strings.EqualFold(a, action)
+	}) {
+		return false
+	}
+	if len(rule.Types) > 0 && !slices.ContainsFunc(rule.Types, func(t string) bool { return sameTokenType(t, c.Type) }) {
+		return false
+	}
+	if rule.IsAdmin != nil && *rule.IsAdmin != c.IsAdmin {
+		return false
+	}
+	if len(rule.Groups) > 0 && !slices.ContainsFunc(rule.Groups, func(g string) bool {
+		return slices.Contains(c.Groups, g)
+	}) {
+		return false
+	}
+	for _, a := range rule.Authorizations {
+		if !c.HasAuthorization(a) {
+			return false
+		}
+	}
+	if len(rule.OrgIDs) > 0 && !slices.Contains(rule.OrgIDs, c.OrgID) {
+		return false
+	}
+	if len(rule.UserIDs) > 0 && !slices.Contains(rule.UserIDs, c.UserID) {
+		return false
+	}
+	if len(rule.Services) > 0 && !slices.Contains(rule.Services, c.Service) {
+		return false
+	}
+	return true
+}
+
+// matchResourcePattern matches '/' separated resources, where '*' matches
+// within one segment (as in path.Match) and a "**" segment matches zero or
+// more segments.
+func matchResourcePattern(pattern string, resource string) bool {
+	return matchSegments(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(resource, "/"), "/"))
+}
+
+// checkResourcePattern returns an error if any segment of the pattern is
+// malformed, as path.Match would report when matching.
+func checkResourcePattern(pattern string) error {
+	for _, segment := range strings.Split(strings.Trim(pattern, "/"), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("resource %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchSegments matches by dynamic programming rather than backtracking,
+// so patterns with several "**" segments take time proportional to the
+// pattern length times the resource length.
+func matchSegments(pattern []string, resource []string) bool {
+	// next[j] records whether pattern[i+1:] matches resource[j:], and
+	// cur[j] whether pattern[i:] does.
+	next := make([]bool, len(resource)+1)
+	cur := make([]bool, len(resource)+1)
+	next[len(resource)] = true
+	for i := len(pattern) - 1; i >= 0; i-- {
+		for j := len(resource); j >= 0; j-- {
+			switch {
+			case pattern[i] == "**":
+				cur[j] = next[j] || (j < len(resource) && cur[j+1])
+			case j == len(resource):
+				cur[j] = false
+			default:
+				matched, err := path.Match(pattern[i], resource[j])
+				cur[j] = err == nil && matched && next[j+1]
+			}
+		}
+		next, cur = cur, next
+	}
+	return next[0]
+}
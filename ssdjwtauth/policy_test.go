@@ -0,0 +1,213 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+default: deny
+rules:
+  - name: admins
+    effect: allow
+    isAdmin: true
+  - name: no-integration-writes
+    effect: deny
+    types: ["integration/v1"]
+    actions: ["POST", "PUT", "DELETE"]
+  - name: deployers
+    effect: allow
+    resources: ["/api/v1/deployments/**"]
+    groups: ["deployers"]
+  - name: internal-artifacts
+    effect: allow
+    types: ["internal-account/v1"]
+    resources: ["/api/v1/artifacts/*"]
+    authorizations: ["artifacts:read"]
+  - name: integration-reads
+    effect: allow
+    types: ["integration/v1"]
+    actions: ["GET"]
+`
+
+func TestPolicy_Decide(t *testing.T) {
+	p, err := NewPolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	admin := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, IsAdmin: true}}
+	deployer := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, Groups: []string{"dev", "deployers"}}}
+	internal := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeInternal, Authorizations: []string{"artifacts:read"}}}
+	integration := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeIntegration}}
+
+	tests := []struct {
+		name     string
+		claims   *SsdJwtClaims
+		resource string
+		action   string
+		want     bool
+		wantRule string
+	}{
+		{"nil claims", nil, "/", "GET", false, ""},
+		{"admin anything", admin, "/anything", "DELETE", true, "admins"},
+		{"deployer nested path", deployer, "/api/v1/deployments/a/b", "POST", true, "deployers"},
+		{"deployer root of tree", deployer, "/api/v1/deployments", "GET", true, "deployers"},
+		{"deployer elsewhere", deployer, "/api/v1/artifacts/x", "GET", false, ""},
+		{"internal single segment", internal, "/api/v1/artifacts/x", "GET", true, "internal-artifacts"},
+		{"internal too deep", internal, "/api/v1/artifacts/x/y", "GET", false, ""},
+		{"integration read", integration, "/api/v1/x", "get", true, "integration-reads"},
+		{"integration write", integration, "/api/v1/x", "POST", false, "no-integration-writes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Decide(tt.claims, tt.resource, tt.action)
+			if got.Allowed != tt.want || got.Rule != tt.wantRule {
+				t.Errorf("Decide() = %+v, want allowed=%v rule=%q", got, tt.want, tt.wantRule)
+			}
+		})
+	}
+}
+
+func Test_matchResourcePattern(t *testing.T) {
+	// A backtracking matcher takes exponential time to reject this.
+	manyStars := strings.Repeat("/**/a", 12) + "/b"
+	manyA := strings.Repeat("/a", 60)
+
+	tests := []struct {
+		pattern  string
+		resource string
+		want     bool
+	}{
+		{"/api/*/x", "/api/v1/x", true},
+		{"/api/*/x", "/api/v1/v2/x", false},
+		{"/api/**", "/api", true},
+		{"/api/**/x", "/api/x", true},
+		{"/api/**/x", "/api/a/b/x", true},
+		{"/api/**/x/**/y", "/api/a/x/b/c/y", true},
+		{"/api/**/x/**/y", "/api/a/x/b/c", false},
+		{"/**", "/", true},
+		{manyStars, manyA, false},
+		{manyStars, manyA + "/b", true},
+	}
+	for _, tt := range tests {
+		if got := matchResourcePattern(tt.pattern, tt.resource); got != tt.want {
+			t.Errorf("matchResourcePattern(%.40q, %.40q) = %v, want %v", tt.pattern, tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestNewPolicy_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"json", `{"default": "allow", "rules": [{"effect": "deny", "groups": ["x"]}]}`, false},
+		{"bad default", "default: maybe", true},
+		{"bad effect", "rules:\n  - effect: permit", true},
+		{"unknown field", "rules:\n  - effect: allow\n    group: [x]", true},
+		{"not yaml", "rules: [", true},
+		{"bad resource pattern", "rules:\n  - effect: deny\n    resources: [\"/admin/[\"]", true},
+		{"bad authorization", "rules:\n  - effect: allow\n    authorizations: [\"artifacts\"]", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicy([]byte(tt.doc))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicy_MiddlewareFunc(t *testing.T) {
+	p, err := NewPolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	handler := p.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	claims := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeIntegration}}
+	for method, want := range map[string]int{"GET": http.StatusOK, "POST": http.StatusForbidden} {
+		r := httptest.NewRequest(method, "/api/v1/x", nil)
+		r = r.WithContext(contextWithToken(r.Context(), claims, "token"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", method, want, w.Code)
+		}
+	}
+
+	// The resource is the cleaned path, so dot segments cannot climb out
+	// of a granted tree.
+	deployer := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, Groups: []string{"deployers"}}}
+	for path, want := range map[string]int{
+		"/api/v1/deployments/x":           http.StatusOK,
+		"/api/v1//deployments/./x":        http.StatusOK,
+		"/api/v1/deployments/../../admin": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.URL.Path = path
+		r = r.WithContext(contextWithToken(r.Context(), deployer, "token"))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", path, want, w.Code)
+		}
+	}
+}
+
+func TestPolicy_maintainFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(filename, []byte("default: deny"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &Policy{}
+	if err := p.maintainFile(ctx, filename, 10*time.Millisecond); err != nil {
+		t.Fatalf("maintainFile: %v", err)
+	}
+	claims := &SsdJwtClaims{}
+	if p.Decide(claims, "/", "GET").Allowed {
+		t.Fatalf("expected initial policy to deny")
+	}
+
+	// ensure the modification time changes even on coarse filesystems
+	later := time.Now().Add(time.Second)
+	if err := os.WriteFile(filename, []byte("default: allow"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !p.Decide(claims, "/", "GET").Allowed {
+		if time.Now().After(deadline) {
+			t.Fatalf("policy was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prommetrics reports ssdjwtauth metrics to Prometheus.
+//
+//	m, err := prommetrics.New(prometheus.DefaultRegisterer)
+//	...
+//	v, err := ssdjwtauth.NewVerifier(keys, nil, ssdjwtauth.WithVerifierMetrics(m))
+//
+// It is a separate module, so that only users of this package depend on
+// the Prometheus client.
+package prommetrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "ssd"
+	subsystem = "auth"
+)
+
+// Metrics implements ssdjwtauth.Metrics using Prometheus collectors.
+type Metrics struct {
+	verified       *prometheus.CounterVec
+	verifyDuration *prometheus.HistogramVec
+	rejected       *prometheus.CounterVec
+	signed         *prometheus.CounterVec
+	signDuration   *prometheus.HistogramVec
+	keyReloads     *prometheus.CounterVec
+	cacheLookups   *prometheus.CounterVec
+}
+
+var _ ssdjwtauth.Metrics = &Metrics{}
+
+// New creates the collectors and registers them with reg.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		verified: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "tokens_verified_total",
+			Help:      "Tokens accepted by the verifier, by token type.",
+		}, []string{"type"}),
+		verifyDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "verify_duration_seconds",
+			Help:      "Time taken to verify accepted tokens, by token type.",
+			Buckets:   []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1},
+		}, []string{"type"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "tokens_rejected_total",
+			Help:      "Tokens rejected, by reason and token type.  The type is empty when the token could not be verified.",
+		}, []string{"reason", "type"}),
+		signed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "tokens_signed_total",
+			Help:      "Tokens issued by the signer, by token type.",
+		}, []string{"type"}),
+		signDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "sign_duration_seconds",
+			Help:      "Time taken to issue tokens, by token type.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1},
+		}, []string{"type"}),
+		keyReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "key_reloads_total",
+			Help:      "Reloads of the verifier's key files, by whether they succeeded.",
+		}, []string{"success"}),
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "token_cache_lookups_total",
+			Help:      "Lookups in the verified-token cache, by whether they hit.",
+		}, []string{"hit"}),
+	}
+	for _, c := range m.collectors() {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.verified,
+		m.verifyDuration,
+		m.rejected,
+		m.signed,
+		m.signDuration,
+		m.keyReloads,
+		m.cacheLookups,
+	}
+}
+
+func (m *Metrics) TokenVerified(tokenType string, duration time.Duration) {
+	m.verified.WithLabelValues(tokenType).Inc()
+	m.verifyDuration.WithLabelValues(tokenType).Observe(duration.Seconds())
+}
+
+func (m *Metrics) TokenRejected(reason ssdjwtauth.RejectReason, tokenType string) {
+	m.rejected.WithLabelValues(string(reason), tokenType).Inc()
+}
+
+func (m *Metrics) TokenSigned(tokenType string, duration time.Duration) {
+	m.signed.WithLabelValues(tokenType).Inc()
+	m.signDuration.WithLabelValues(tokenType).Observe(duration.Seconds())
+}
+
+func (m *Metrics) KeysReloaded(err error) {
+	m.keyReloads.WithLabelValues(strconv.FormatBool(err == nil)).Inc()
+}
+
+func (m *Metrics) TokenCacheLookup(hit bool) {
+	m.cacheLookups.WithLabelValues(strconv.FormatBool(hit)).Inc()
+}
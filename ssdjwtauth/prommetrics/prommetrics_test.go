@@ -0,0 +1,71 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prommetrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := New(reg); err == nil {
+		t.Errorf("expected error registering twice")
+	}
+
+	m.TokenVerified(ssdjwtauth.SSDTokenTypeUser, time.Millisecond)
+	m.TokenVerified(ssdjwtauth.SSDTokenTypeUser, time.Millisecond)
+	m.TokenRejected(ssdjwtauth.RejectExpired, "")
+	m.TokenSigned(ssdjwtauth.SSDTokenTypeService, time.Millisecond)
+	m.KeysReloaded(nil)
+	m.KeysReloaded(errors.New("failed"))
+	m.TokenCacheLookup(true)
+
+	tests := []struct {
+		name string
+		c    prometheus.Collector
+		want float64
+	}{
+		{"verified", m.verified.WithLabelValues(ssdjwtauth.SSDTokenTypeUser), 2},
+		{"rejected", m.rejected.WithLabelValues("expired", ""), 1},
+		{"signed", m.signed.WithLabelValues(ssdjwtauth.SSDTokenTypeService), 1},
+		{"reload succeeded", m.keyReloads.WithLabelValues("true"), 1},
+		{"reload failed", m.keyReloads.WithLabelValues("false"), 1},
+		{"cache hit", m.cacheLookups.WithLabelValues("true"), 1},
+		{"cache miss", m.cacheLookups.WithLabelValues("false"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testutil.ToFloat64(tt.c); got != tt.want {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := testutil.CollectAndCount(m.verifyDuration); got != 1 {
+		t.Errorf("verify duration series = %d, want 1", got)
+	}
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("gathering metrics: %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReferenceTokenPrefix starts every opaque reference token, which lets
+// the Verifier tell them apart from JWTs.
+const ReferenceTokenPrefix = "ssdref_"
+
+var ErrReferenceNotFound = errors.New("reference token not found")
+
+// ReferenceStore maps opaque reference tokens to the claims they stand for.
+// Implementations must not return claims which have expired or been revoked.
+type ReferenceStore interface {
+	Put(ref string, claims *SsdJwtClaims) error
+	// Get returns ErrReferenceNotFound for unknown, expired or revoked references.
+	Get(ref string) (*SsdJwtClaims, error)
+	Revoke(ref string) error
+}
+
+// WithReferenceStore lets the Signer issue reference tokens, see SignReferenceToken.
+func WithReferenceStore(store ReferenceStore) SignerOption {
+	return func(s *Signer) {
+		s.referenceStore = store
+	}
+}
+
+// IsReferenceToken reports whether the token is an opaque reference token
+// rather than a JWT.
+func IsReferenceToken(token string) bool {
+	return strings.HasPrefix(token, ReferenceTokenPrefix)
+}
+
+// SignReferenceToken stores the claims in the Signer's ReferenceStore and
+// returns a random reference token standing for them.  Unlike a JWT, it can
+// be revoked instantly by removing it from the store.
+func (s *Signer) SignReferenceToken(claims SsdJwtClaims) (string, error) {
+	start := time.Now()
+	if s.referenceStore == nil {
+		return "", fmt.Errorf("signer has no reference store")
+	}
+	if err := ValidateSSDClaims(&claims.SSDCLaims); err != nil {
+		return "", err
+	}
+	if claims.ExpiresAt == nil {
+		return "", fmt.Errorf("reference tokens require an expiry")
+	}
+	if err := s.checkLifetime(&claims); err != nil {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ref := ReferenceTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	if err := s.auditIssued(&claims); err != nil {
+		return "", fmt.Errorf("unable to audit token: %v", err)
+	}
+	if err := s.referenceStore.Put(ref, &claims); err != nil {
+		return "", err
+	}
+	s.getMetrics().TokenSigned(claims.SSDCLaims.Type, time.Since(start))
+	s.logger().Debug("reference token issued", claimsAttrs(&claims)...)
+	return ref, nil
+}
+
+// MemoryReferenceStore is a ReferenceStore kept in memory, for a single
+// token issuer.  Expired references are removed as new ones are added.
+type MemoryReferenceStore struct {
+	sync.Mutex
+	// Clock defaults to the system clock.
+	Clock Clock
+
+	refs      map[string]*SsdJwtClaims
+	nextPrune int
+}
+
+const minReferencePrune = 1024
+
+func NewMemoryReferenceStore() *MemoryReferenceStore {
+	return &MemoryReferenceStore{
+		refs:      map[string]*SsdJwtClaims{},
+		nextPrune: minReferencePrune,
+	}
+}
+
+func (m *MemoryReferenceStore) Put(ref string, claims *SsdJwtClaims) error {
+	m.Lock()
+	defer m.Unlock()
+	m.refs[ref] = claims.clone()
+	if len(m.refs) >= m.nextPrune {
+		now := clockOrSystem(m.Clock).Now()
+		for r, c := range m.refs {
+			if referenceExpired(c, now) {
+				delete(m.refs, r)
+			}
+		}
+		m.nextPrune = max(2*len(m.refs), minReferencePrune)
+	}
+	return nil
+}
+
+func (m *MemoryReferenceStore) Get(ref string) (*SsdJwtClaims, error) {
+	m.Lock()
+	defer m.Unlock()
+	claims, found := m.refs[ref]
+	if !found {
+		return nil, ErrReferenceNotFound
+	}
+	if referenceExpired(claims, clockOrSystem(m.Clock).Now()) {
+		delete(m.refs, ref)
+		return nil, ErrReferenceNotFound
+	}
+	return claims.clone(), nil
+}
+
+func (m *MemoryReferenceStore) Revoke(ref string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.refs, ref)
+	return nil
+}
+
+func referenceExpired(claims *SsdJwtClaims, now time.Time) bool {
+	return claims.ExpiresAt == nil || !now.Before(claims.ExpiresAt.Time)
+}
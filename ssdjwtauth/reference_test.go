@@ -0,0 +1,361 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestReferenceSigner(t *testing.T) (*Signer, *MemoryReferenceStore) {
+	t.Helper()
+	private, _ := testKeyPEMs(t)
+	store := NewMemoryReferenceStore()
+	s, err := NewSigner("testkey", private, WithReferenceStore(store))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	return s, store
+}
+
+func TestSignReferenceToken(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	now := time.Now()
+	ref, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "id", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+	if !IsReferenceToken(ref) {
+		t.Errorf("expected %s to be a reference token", ref)
+	}
+	claims, err := store.Get(ref)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if claims.SSDCLaims.UserID != "alice" {
+		t.Errorf("unexpected claims %+v", claims.SSDCLaims)
+	}
+
+	if err := store.Revoke(ref); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := store.Get(ref); err != ErrReferenceNotFound {
+		t.Errorf("expected ErrReferenceNotFound after revocation, got %v", err)
+	}
+
+	expired, err := s.SignReferenceToken(s.MakeClaims(now.Add(-time.Hour), now.Add(-time.Minute), "id", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+	if _, err := store.Get(expired); err != ErrReferenceNotFound {
+		t.Errorf("expected ErrReferenceNotFound for an expired reference, got %v", err)
+	}
+
+	if _, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{Type: SSDTokenTypeUser})); err == nil {
+		t.Errorf("expected invalid claims to be rejected")
+	}
+	plain, _ := newTestSignerVerifier(t)
+	if _, err := plain.SignReferenceToken(plain.MakeClaims(now, now.Add(time.Hour), "id", testUserClaims)); err == nil {
+		t.Errorf("expected a signer without a store to fail")
+	}
+}
+
+func introspect(t *testing.T, h http.Handler, token string) (int, map[string]any) {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/introspect", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	response := map[string]any{}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("unable to parse response: %v", err)
+		}
+	}
+	return w.Code, response
+}
+
+func TestIntrospectionHandler(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	now := time.Now()
+	ssd := SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", Authorizations: []string{"a:read", "b:write"}}
+	ref, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "jti-1", ssd))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+	h := IntrospectionHandler(store)
+
+	status, response := introspect(t, h, ref)
+	if status != http.StatusOK || response["active"] != true {
+		t.Fatalf("expected an active token, got %d %v", status, response)
+	}
+	if response["jti"] != "jti-1" || response["scope"] != "a:read b:write" || response["exp"] == nil {
+		t.Errorf("unexpected introspection response %v", response)
+	}
+	if _, found := response["ssd.opsmx.io"]; !found {
+		t.Errorf("expected SSD claims in the response")
+	}
+
+	status, response = introspect(t, h, ReferenceTokenPrefix+"unknown")
+	if status != http.StatusOK || response["active"] != false || len(response) != 1 {
+		t.Errorf("expected only active=false for an unknown token, got %d %v", status, response)
+	}
+
+	if status, _ := introspect(t, h, ""); status != http.StatusBadRequest {
+		t.Errorf("expected 400 without a token, got %d", status)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/introspect", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestIntrospectionClient(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	now := time.Now()
+	ref, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "id", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+
+	var requests atomic.Int32
+	var gotAuth atomic.Value
+	handler := IntrospectionHandler(store)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		gotAuth.Store(r.Header.Get("Authorization"))
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := &IntrospectionClient{URL: server.URL, Token: "caller-token", TTL: time.Minute}
+	_, public := testKeyPEMs(t)
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, nil, WithIntrospector(client))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	var gotUser string
+	mw := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := SSDClaimsFromContext(r.Context())
+		gotUser = claims.SSDCLaims.UserID
+	}))
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+ref)
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || gotUser != "alice" {
+			t.Fatalf("expected reference token to be accepted, got %d", w.Code)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 introspection request due to caching, got %d", n)
+	}
+	if gotAuth.Load() != "Bearer caller-token" {
+		t.Errorf("expected the client to authenticate, got %v", gotAuth.Load())
+	}
+
+	// JWTs are still accepted
+	jwtSigner, _ := newTestSignerVerifier(t)
+	if _, err := v.VerifyToken(signTestToken(t, jwtSigner, testUserClaims)); err != nil {
+		t.Errorf("expected JWT to still verify: %v", err)
+	}
+
+	if _, err := v.VerifyToken(ReferenceTokenPrefix + "unknown"); err == nil {
+		t.Errorf("expected unknown reference token to be rejected")
+	}
+	plain, _ := NewVerifier(map[string][]byte{"testkey": public}, nil)
+	if _, err := plain.VerifyToken(ref); err == nil {
+		t.Errorf("expected verifier without an introspector to reject reference tokens")
+	}
+}
+
+func TestStoreIntrospector(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	now := time.Now()
+	ref, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "id", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+	_, public := testKeyPEMs(t)
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, nil, WithIntrospector(StoreIntrospector{store}))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	if _, err := v.VerifyToken(ref); err != nil {
+		t.Errorf("VerifyToken: %v", err)
+	}
+	store.Revoke(ref)
+	if _, err := v.VerifyToken(ref); err == nil {
+		t.Errorf("expected revoked reference token to be rejected immediately")
+	}
+}
+
+type staticIntrospector struct {
+	claims *SsdJwtClaims
+}
+
+func (s staticIntrospector) Introspect(ctx context.Context, token string) (*SsdJwtClaims, error) {
+	return s.claims, nil
+}
+
+func TestVerifyReferenceToken_checksClaims(t *testing.T) {
+	s, _ := newTestReferenceSigner(t)
+	now := time.Now()
+	valid := s.MakeClaims(now, now.Add(time.Hour), "id", testUserClaims)
+
+	tests := []struct {
+		name    string
+		modify  func(c *SsdJwtClaims)
+		wantErr bool
+	}{
+		{"valid", func(c *SsdJwtClaims) {}, false},
+		{"expired", func(c *SsdJwtClaims) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(-2 * time.Hour))
+			c.NotBefore = c.IssuedAt
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Hour))
+		}, true},
+		{"no expiry", func(c *SsdJwtClaims) { c.ExpiresAt = nil }, true},
+		{"not yet valid", func(c *SsdJwtClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Hour)) }, true},
+		{"wrong issuer", func(c *SsdJwtClaims) { c.Issuer = "someone-else" }, true},
+		{"wrong audience", func(c *SsdJwtClaims) { c.Audience = jwt.ClaimStrings{"someone-else"} }, true},
+	}
+	_, public := testKeyPEMs(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid.clone()
+			tt.modify(c)
+			v, err := NewVerifier(map[string][]byte{"testkey": public}, nil, WithIntrospector(staticIntrospector{c}))
+			if err != nil {
+				t.Fatalf("NewVerifier: %v", err)
+			}
+			_, err = v.VerifyToken(ReferenceTokenPrefix + "abc")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMemoryReferenceStore_copiesClaims(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	now := time.Now()
+	claims := s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{
+		Type:   SSDTokenTypeUser,
+		UserID: "alice",
+		OrgID:  "org",
+		Groups: []string{"group1"},
+	})
+	ref, err := s.SignReferenceToken(claims)
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+	got, err := store.Get(ref)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got.SSDCLaims.Groups[0] = "admins"
+	got.ExpiresAt.Time = now.Add(24 * time.Hour)
+
+	again, err := store.Get(ref)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if again.SSDCLaims.Groups[0] != "group1" {
+		t.Errorf("store groups modified through returned claims: %v", again.SSDCLaims.Groups)
+	}
+	if !again.ExpiresAt.Time.Equal(claims.ExpiresAt.Time) {
+		t.Errorf("store expiry modified through returned claims: %v", again.ExpiresAt)
+	}
+}
+
+func TestIntrospectionClient_clock(t *testing.T) {
+	s, store := newTestReferenceSigner(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeFunc := TimeFunc(func() time.Time { return now })
+	store.Clock = timeFuncClock{now: timeFunc}
+	ref, err := s.SignReferenceToken(s.MakeClaims(now, now.Add(time.Hour), "id", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignReferenceToken: %v", err)
+	}
+	server := httptest.NewServer(IntrospectionHandler(store))
+	defer server.Close()
+
+	_, public := testKeyPEMs(t)
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, &timeFunc,
+		WithIntrospector(&IntrospectionClient{URL: server.URL, Clock: store.Clock}))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	if _, err := v.VerifyToken(ref); err != nil {
+		t.Errorf("VerifyToken at the verifier's time: %v", err)
+	}
+}
+
+func TestIntrospectionClient_hungEndpoint(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{"timeout", 50 * time.Millisecond, func() (context.Context, context.CancelFunc) {
+			return context.Background(), func() {}
+		}},
+		{"request cancelled", time.Hour, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &IntrospectionClient{URL: server.URL, Timeout: tt.timeout}
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				_, err := client.Introspect(ctx, ReferenceTokenPrefix+"x")
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Errorf("expected introspection of a hung endpoint to fail")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("introspection of a hung endpoint did not return")
+			}
+		})
+	}
+}
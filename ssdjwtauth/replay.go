@@ -0,0 +1,156 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultReplayCacheSize is the capacity of a MemoryReplayCache with no
+// size set.
+const defaultReplayCacheSize = 100000
+
+// ErrTokenReplayed is returned when a single-use token is presented again.
+var ErrTokenReplayed = errors.New("token has already been used")
+
+// ReplayCache remembers the jti of single-use tokens until they expire.
+// Implementations shared between replicas (e.g. using Redis SETNX) give
+// protection across the whole service rather than one process.
+type ReplayCache interface {
+	// MarkUsed records that id has been used until expiry, returning
+	// false if it was already recorded and has not yet expired.
+	MarkUsed(id string, expiry time.Time) (bool, error)
+}
+
+// WithReplayCache rejects reuse of tokens with the "once" claim, and of
+// every token of the listed types in any version, by recording their jti
+// in cache.
+// Single-use tokens must have both a jti and an expiry.  Tokens with the
+// "once" claim are rejected by a Verifier with no replay cache.
+func WithReplayCache(cache ReplayCache, tokenTypes ...string) VerifierOption {
+	return func(v *Verifier) {
+		v.replayCache = cache
+		v.onceTypes = tokenTypes
+	}
+}
+
+// checkReplay consumes a single-use token, so that it cannot be used again.
+func (v *Verifier) checkReplay(claims *SsdJwtClaims) error {
+	if !v.singleUse(claims) {
+		return nil
+	}
+	if err := v.checkSingleUse(claims); err != nil {
+		return err
+	}
+	// the token is accepted until the leeway past exp, so it must be
+	// remembered until then too
+	fresh, err := v.replayCache.MarkUsed(claims.ID, claims.ExpiresAt.Add(tokenLeeway))
+	if err != nil {
+		return fmt.Errorf("replay cache: %v", err)
+	}
+	if !fresh {
+		return ErrTokenReplayed
+	}
+	return nil
+}
+
+func (v *Verifier) singleUse(claims *SsdJwtClaims) bool {
+	return claims.SSDCLaims.Once || slices.ContainsFunc(v.onceTypes, func(t string) bool { return sameTokenType(t, claims.SSDCLaims.Type) })
+}
+
+// checkSingleUse returns an error if a single-use token cannot be
+// accepted at all, without consuming it.
+func (v *Verifier) checkSingleUse(claims *SsdJwtClaims) error {
+	if v.replayCache == nil {
+		return fmt.Errorf("single-use tokens are not accepted")
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return fmt.Errorf("single-use token requires jti and exp")
+	}
+	return nil
+}
+
+// MemoryReplayCache is an in-process ReplayCache holding up to Size
+// entries.  When full, the least recently added entry is dropped, which
+// would allow that token to be used again before it expires, so Size
+// should exceed the number of single-use tokens issued per token lifetime.
+// The zero value is ready to use.
+type MemoryReplayCache struct {
+	// Size is the maximum number of entries.  Zero means 100000.
+	Size int
+	// Clock defaults to the system clock.  A Verifier with another clock
+	// (see WithVerifierClock) should be given a cache with the same one,
+	// so that entries expire by the same clock as the tokens.
+	Clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   list.List
+}
+
+type replayEntry struct {
+	id     string
+	expiry time.Time
+}
+
+// NewMemoryReplayCache returns a MemoryReplayCache holding up to size entries.
+func NewMemoryReplayCache(size int) *MemoryReplayCache {
+	return &MemoryReplayCache{Size: size}
+}
+
+func (c *MemoryReplayCache) MarkUsed(id string, expiry time.Time) (bool, error) {
+	return c.markUsed(clockOrSystem(c.Clock).Now(), id, expiry)
+}
+
+// markUsed is MarkUsed at the given time.
+func (c *MemoryReplayCache) markUsed(now time.Time, id string, expiry time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*list.Element{}
+	}
+
+	if e, found := c.entries[id]; found {
+		if now.Before(e.Value.(*replayEntry).expiry) {
+			return false, nil
+		}
+		c.remove(e)
+	}
+
+	// Entries are usually added with the same lifetime, so expired ones
+	// collect at the front.
+	for e := c.order.Front(); e != nil && !now.Before(e.Value.(*replayEntry).expiry); e = c.order.Front() {
+		c.remove(e)
+	}
+	size := c.Size
+	if size <= 0 {
+		size = defaultReplayCacheSize
+	}
+	for c.order.Len() >= size {
+		c.remove(c.order.Front())
+	}
+	c.entries[id] = c.order.PushBack(&replayEntry{id: id, expiry: expiry})
+	return true, nil
+}
+
+func (c *MemoryReplayCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.entries, e.Value.(*replayEntry).id)
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryReplayCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := &MemoryReplayCache{Size: 2, Clock: timeFuncClock{now: func() time.Time { return now }}}
+
+	steps := []struct {
+		name    string
+		id      string
+		expiry  time.Time
+		advance time.Duration
+		want    bool
+	}{
+		{"first use", "a", now.Add(time.Minute), 0, true},
+		{"reuse", "a", now.Add(time.Minute), 0, false},
+		{"second id", "b", now.Add(time.Hour), 0, true},
+		{"evicts oldest", "c", now.Add(time.Hour), 0, true},
+		{"evicted id is fresh", "a", now.Add(time.Hour), 0, true},
+		{"c still seen", "c", now.Add(time.Hour), 0, false},
+		{"after expiry", "c", now.Add(3 * time.Hour), 2 * time.Hour, true},
+	}
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		got, err := c.MarkUsed(step.id, step.expiry)
+		if err != nil {
+			t.Fatalf("%s: MarkUsed: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: MarkUsed(%s) = %v, want %v", step.name, step.id, got, step.want)
+		}
+	}
+}
+
+func TestSingleUseTokens(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	once := testUserClaims
+	once.Once = true
+	service := SSDClaims{Type: SSDTokenTypeService, OrgID: "org1", Service: "svc", Instance: "svc-1"}
+
+	sign := func(ssd SSDClaims, id string) string {
+		now := time.Now()
+		token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), id, ssd))
+		if err != nil {
+			t.Fatalf("SignToken: %v", err)
+		}
+		return token
+	}
+
+	if _, err := v.VerifyToken(sign(once, "no-cache")); err == nil {
+		t.Errorf("expected once token to be rejected without a replay cache")
+	}
+
+	WithReplayCache(NewMemoryReplayCache(0), SSDTokenTypeService)(v)
+	onceToken := sign(once, "once-1")
+	serviceToken := sign(service, "service-1")
+	plainToken := sign(testUserClaims, "plain-1")
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+		fail    bool
+	}{
+		{"once claim first use", onceToken, nil, false},
+		{"once claim reuse", onceToken, ErrTokenReplayed, true},
+		{"type first use", serviceToken, nil, false},
+		{"type reuse", serviceToken, ErrTokenReplayed, true},
+		{"plain token", plainToken, nil, false},
+		{"plain token again", plainToken, nil, false},
+		{"once without jti", sign(once, ""), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.VerifyToken(tt.token)
+			if (err != nil) != tt.fail {
+				t.Fatalf("VerifyToken() error = %v, wantErr %v", err, tt.fail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSingleUseTokens_leeway(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	clock := timeFuncClock{now: func() time.Time { return now }}
+	WithVerifierClock(clock)(v)
+	WithReplayCache(&MemoryReplayCache{Clock: clock})(v)
+
+	once := testUserClaims
+	once.Once = true
+	token, err := s.SignToken(s.MakeClaims(start, start.Add(time.Hour), "once-1", once))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	if _, err := v.VerifyToken(token); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	// past exp, but still accepted within the leeway
+	now = start.Add(time.Hour + tokenLeeway/2)
+	if _, err := v.VerifyToken(token); !errors.Is(err, ErrTokenReplayed) {
+		t.Errorf("reuse within the leeway: error = %v, want %v", err, ErrTokenReplayed)
+	}
+	now = start.Add(time.Hour + tokenLeeway + time.Second)
+	if _, err := v.VerifyToken(token); errors.Is(err, ErrTokenReplayed) || err == nil {
+		t.Errorf("after the leeway: error = %v, want the token to be expired", err)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope is a parsed authorization, of the form "resource:action".
+//
+// The resource is a '/' separated path such as "artifacts" or
+// "org/<id>/policies", and the action is a single word such as "read".
+// In granted scopes, a "*" segment or action matches any single value,
+// and a trailing "**" segment matches any number of further segments.
+// A grant on a resource also covers everything beneath it, so
+// "org/1234:read" covers "org/1234/policies:read".
+type Scope struct {
+	Resource []string
+	Action   string
+}
+
+// ParseScope parses an authorization string into a Scope.
+func ParseScope(s string) (Scope, error) {
+	resource, action, found := strings.Cut(s, ":")
+	if !found {
+		return Scope{}, fmt.Errorf("scope %q is not of the form resource:action", s)
+	}
+	if !validScopeWord(action) {
+		return Scope{}, fmt.Errorf("scope %q has an invalid action", s)
+	}
+	segments := strings.Split(resource, "/")
+	for i, segment := range segments {
+		if segment == "**" && i == len(segments)-1 {
+			continue
+		}
+		if !validScopeWord(segment) {
+			return Scope{}, fmt.Errorf("scope %q has an invalid resource", s)
+		}
+	}
+	return Scope{Resource: segments, Action: action}, nil
+}
+
+func validScopeWord(s string) bool {
+	if s == "*" {
+		return true
+	}
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !alphanumeric(c) && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Scope) String() string {
+	return strings.Join(s.Resource, "/") + ":" + s.Action
+}
+
+// Covers reports whether the granted scope s permits the requested scope.
+// Wildcards in the requested scope are not expanded, so a request for
+// "artifacts:*" is only covered by a grant of all actions on artifacts.
+func (s Scope) Covers(requested Scope) bool {
+	if s.Action != "*" && s.Action != requested.Action {
+		return false
+	}
+	for i, segment := range s.Resource {
+		if segment == "**" {
+			return true
+		}
+		if i >= len(requested.Resource) {
+			return false
+		}
+		if segment != "*" && segment != requested.Resource[i] {
+			return false
+		}
+	}
+	// every granted segment matched, so requested is the same resource or beneath it
+	return true
+}
+
+// HasAuthorization reports whether any of the granted authorizations covers
+// the requested one.  An authorization which is not a scope, such as the
+// flat "admin" of older tokens, only matches exactly the same string.
+func HasAuthorization(granted []string, requested string) bool {
+	req, err := ParseScope(requested)
+	for _, g := range granted {
+		if g == requested {
+			return true
+		}
+		if err != nil {
+			continue
+		}
+		scope, err := ParseScope(g)
+		if err != nil {
+			continue
+		}
+		if scope.Covers(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAuthorization reports whether the claims grant the requested authorization.
+func (c *SSDClaims) HasAuthorization(requested string) bool {
+	return HasAuthorization(c.Authorizations, requested)
+}
+
+// HasAuthorization reports whether the claims grant the requested authorization.
+func (c *SSDInternalClaims) HasAuthorization(requested string) bool {
+	return HasAuthorization(c.Authorizations, requested)
+}
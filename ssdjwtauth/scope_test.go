@@ -0,0 +1,95 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name    string
+		scope   string
+		want    Scope
+		wantErr bool
+	}{
+		{"simple", "deployments:write", Scope{[]string{"deployments"}, "write"}, false},
+		{"wildcard action", "artifacts:*", Scope{[]string{"artifacts"}, "*"}, false},
+		{"hierarchy", "org/1234/policies:read", Scope{[]string{"org", "1234", "policies"}, "read"}, false},
+		{"trailing doublestar", "org/**:read", Scope{[]string{"org", "**"}, "read"}, false},
+		{"no action", "deployments", Scope{}, true},
+		{"empty action", "deployments:", Scope{}, true},
+		{"empty resource", ":read", Scope{}, true},
+		{"empty segment", "org//policies:read", Scope{}, true},
+		{"doublestar not last", "**/policies:read", Scope{}, true},
+		{"bad characters", "deploy ments:write", Scope{}, true},
+		{"two colons", "a:b:c", Scope{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseScope(tt.scope)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseScope() = %v, want %v", got, tt.want)
+			}
+			if !tt.wantErr && got.String() != tt.scope {
+				t.Errorf("String() = %s, want %s", got.String(), tt.scope)
+			}
+		})
+	}
+}
+
+func TestHasAuthorization(t *testing.T) {
+	tests := []struct {
+		name      string
+		granted   []string
+		requested string
+		want      bool
+	}{
+		{"exact", []string{"deployments:write"}, "deployments:write", true},
+		{"other action", []string{"deployments:read"}, "deployments:write", false},
+		{"other resource", []string{"artifacts:write"}, "deployments:write", false},
+		{"wildcard action", []string{"artifacts:*"}, "artifacts:delete", true},
+		{"wildcard segment", []string{"org/*/policies:read"}, "org/1234/policies:read", true},
+		{"wildcard segment other resource", []string{"org/*/policies:read"}, "org/1234/teams:read", false},
+		{"parent covers child", []string{"org/1234:read"}, "org/1234/policies:read", true},
+		{"child does not cover parent", []string{"org/1234/policies:read"}, "org/1234:read", false},
+		{"sibling org", []string{"org/1234:read"}, "org/5678/policies:read", false},
+		{"doublestar", []string{"org/**:*"}, "org/1/teams/2:write", true},
+		{"requested wildcard needs wildcard", []string{"artifacts:read"}, "artifacts:*", false},
+		{"requested wildcard granted", []string{"artifacts:*"}, "artifacts:*", true},
+		{"malformed grant ignored", []string{"garbage", "artifacts:read"}, "artifacts:read", true},
+		{"malformed request", []string{"*:*"}, "garbage", false},
+		{"flat authorization", []string{"deploy", "admin"}, "admin", true},
+		{"flat authorization other name", []string{"admin"}, "administrator", false},
+		{"flat authorization is not a wildcard", []string{"*"}, "admin", false},
+		{"flat request not covered by scopes", []string{"*:*"}, "admin", false},
+		{"nothing granted", nil, "artifacts:read", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasAuthorization(tt.granted, tt.requested); got != tt.want {
+				t.Errorf("HasAuthorization(%v, %s) = %v, want %v", tt.granted, tt.requested, got, tt.want)
+			}
+			c := &SSDInternalClaims{Authorizations: tt.granted}
+			if got := c.HasAuthorization(tt.requested); got != tt.want {
+				t.Errorf("SSDInternalClaims.HasAuthorization(%s) = %v, want %v", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
@@ -17,6 +17,7 @@ package ssdjwtauth
 import (
 	"crypto"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -27,26 +28,59 @@ type Signer struct {
 	sync.Mutex
 	KeyID string
 	Key   crypto.PrivateKey
+
+	// emitVersions maps a token type name to the version to emit, if
+	// it is not the current version.
+	emitVersions map[string]int
+
+	groupEncoder   GroupEncoder
+	groupThreshold int
+	referenceStore ReferenceStore
+	metrics        Metrics
+	log            *slog.Logger
+	auditSink      AuditSink
+	lifetime       *LifetimePolicy
+	clock          Clock
+}
+
+// SignerOption configures optional Signer behavior.
+type SignerOption func(*Signer)
+
+// WithTokenVersion makes the Signer emit an older version of a token type,
+// such as WithTokenVersion("user", 1), while verifiers are being upgraded to
+// accept the current version.
+func WithTokenVersion(name string, version int) SignerOption {
+	return func(s *Signer) {
+		s.emitVersions[name] = version
+	}
 }
 
-func NewSigner(keyID string, pemkey []byte) (*Signer, error) {
+func NewSigner(keyID string, pemkey []byte, opts ...SignerOption) (*Signer, error) {
 	rk, err := jwt.ParseRSAPrivateKeyFromPEM(pemkey)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse private key PEM for keyid %s: %v", keyID, err)
 	}
 	s := &Signer{
-		KeyID: keyID,
-		Key:   rk,
+		KeyID:        keyID,
+		Key:          rk,
+		emitVersions: map[string]int{},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	return s, nil
 }
 
+// MakeClaims returns claims valid from now until expiry.  Both nbf and iat
+// are set to now; iat lets a LifetimePolicy measure the token's lifetime
+// from when it was issued.
 func (s *Signer) MakeClaims(now time.Time, expiry time.Time, id string, ssd SSDClaims) SsdJwtClaims {
 	return SsdJwtClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    ssdTokenIssuer,
 			Audience:  []string{ssdTokenAudience},
 			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(expiry),
 			ID:        id,
 		},
@@ -54,6 +88,13 @@ func (s *Signer) MakeClaims(now time.Time, expiry time.Time, id string, ssd SSDC
 	}
 }
 
+// NewClaims is MakeClaims for a token valid from the current time, as
+// given by the Signer's clock, for the given lifetime.
+func (s *Signer) NewClaims(lifetime time.Duration, id string, ssd SSDClaims) SsdJwtClaims {
+	now := s.now()
+	return s.MakeClaims(now, now.Add(lifetime), id, ssd)
+}
+
 func (s *Signer) SetSigningKey(keyID string, pemkey []byte) error {
 	rk, err := jwt.ParseRSAPrivateKeyFromPEM(pemkey)
 	if err != nil {
@@ -67,6 +108,44 @@ func (s *Signer) SetSigningKey(keyID string, pemkey []byte) error {
 }
 
 func (s *Signer) SignToken(claims SsdJwtClaims) (string, error) {
+	start := time.Now()
+	token, err := s.signToken(claims)
+	if err != nil {
+		return "", err
+	}
+	if err := s.auditIssued(&claims); err != nil {
+		return "", fmt.Errorf("unable to audit token: %v", err)
+	}
+	s.getMetrics().TokenSigned(claims.SSDCLaims.Type, time.Since(start))
+	s.logger().Debug("token issued", claimsAttrs(&claims)...)
+	return token, nil
+}
+
+func (s *Signer) signToken(claims SsdJwtClaims) (string, error) {
+	if err := ValidateSSDClaims(&claims.SSDCLaims); err != nil {
+		return "", err
+	}
+	if err := s.checkLifetime(&claims); err != nil {
+		return "", err
+	}
+	// Claims of an older version are upgraded first, so the token carries
+	// the current version unless WithTokenVersion selects another.
+	if err := UpgradeSSDClaims(&claims.SSDCLaims); err != nil {
+		return "", err
+	}
+	name, version, err := ParseTokenType(claims.SSDCLaims.Type)
+	if err != nil {
+		return "", err
+	}
+	if emit, found := s.emitVersions[name]; found && emit != version {
+		claims.SSDCLaims, err = DowngradeSSDClaims(claims.SSDCLaims, emit)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := s.encodeGroups(&claims.SSDCLaims); err != nil {
+		return "", err
+	}
 	token := jwt.NewWithClaims(signingMethod, claims)
 
 	s.Lock()
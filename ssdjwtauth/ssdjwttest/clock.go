@@ -0,0 +1,122 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ssdjwttest provides helpers for testing code which uses
+// ssdjwtauth.
+package ssdjwttest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+)
+
+// FakeClock is an ssdjwtauth.Clock whose time only changes when Set or
+// Advance is called.  Tickers fire when the time is moved past their next
+// tick, at most once per call as with a slow time.Ticker.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+var _ ssdjwtauth.Clock = &FakeClock{}
+
+// NewFakeClock returns a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing any tickers which are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.setLocked(c.now.Add(d))
+}
+
+// Set moves the clock to t, firing any tickers which are due.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.setLocked(t)
+}
+
+// setLocked is called with the lock held, and releases it.
+func (c *FakeClock) setLocked(t time.Time) {
+	c.now = t
+	var due []*fakeTicker
+	for _, ticker := range c.tickers {
+		if !ticker.next.After(t) {
+			for !ticker.next.After(t) {
+				ticker.next = ticker.next.Add(ticker.d)
+			}
+			due = append(due, ticker)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, ticker := range due {
+		select {
+		case ticker.c <- t:
+		default:
+		}
+	}
+}
+
+// NewTicker returns a ticker which fires as the clock is advanced.
+func (c *FakeClock) NewTicker(d time.Duration) ssdjwtauth.Ticker {
+	if d <= 0 {
+		panic("ssdjwttest: non-positive interval for NewTicker")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ticker := &fakeTicker{clock: c, d: d, next: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.tickers = append(c.tickers, ticker)
+	return ticker
+}
+
+// Tickers returns the number of tickers which have not been stopped, so
+// tests can wait for background goroutines to start.
+func (c *FakeClock) Tickers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+type fakeTicker struct {
+	clock *FakeClock
+	d     time.Duration
+	next  time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, ticker := range t.clock.tickers {
+		if ticker == t {
+			t.clock.tickers = append(t.clock.tickers[:i], t.clock.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwttest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	c := NewFakeClock(start)
+	ticker := c.NewTicker(time.Minute)
+
+	expectTick := func(want bool) {
+		t.Helper()
+		select {
+		case <-ticker.C():
+			if !want {
+				t.Errorf("unexpected tick at %v", c.Now())
+			}
+		default:
+			if want {
+				t.Errorf("expected a tick at %v", c.Now())
+			}
+		}
+	}
+
+	c.Advance(30 * time.Second)
+	expectTick(false)
+	c.Advance(30 * time.Second)
+	if got := c.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Now() = %v, want %v", got, start.Add(time.Minute))
+	}
+	expectTick(true)
+	c.Advance(5 * time.Minute)
+	expectTick(true)
+	expectTick(false)
+	c.Advance(time.Minute)
+	expectTick(true)
+
+	c.Set(start)
+	expectTick(false)
+
+	ticker.Stop()
+	if c.Tickers() != 0 {
+		t.Errorf("Tickers() = %d after Stop, want 0", c.Tickers())
+	}
+	c.Advance(time.Hour)
+	expectTick(false)
+}
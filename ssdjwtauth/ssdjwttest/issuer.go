@@ -0,0 +1,277 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwttest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+)
+
+// Defaults used in the claims of tokens minted by an Issuer.
+const (
+	DefaultKeyID       = "ssdjwttest"
+	DefaultOrgID       = "test-org"
+	DefaultUserID      = "test-user"
+	DefaultGroup       = "test-group"
+	DefaultService     = "test-service"
+	DefaultInstance    = "test-instance"
+	DefaultTeamID      = "test-team"
+	DefaultTokenExpiry = time.Hour
+)
+
+// Issuer mints tokens for tests, using a key generated when it is created,
+// and provides a Verifier which accepts them.
+type Issuer struct {
+	Signer   *ssdjwtauth.Signer
+	Verifier *ssdjwtauth.Verifier
+	// KeyID identifies the signing key, and PublicKeyPEM is its public key
+	// in the form accepted by ssdjwtauth.NewVerifier.
+	KeyID        string
+	PublicKeyPEM []byte
+}
+
+// IssuerOption configures an Issuer.
+type IssuerOption func(*issuerConfig)
+
+type issuerConfig struct {
+	keyID           string
+	clock           ssdjwtauth.Clock
+	signerOptions   []ssdjwtauth.SignerOption
+	verifierOptions []ssdjwtauth.VerifierOption
+}
+
+// WithKeyID sets the key id, which defaults to DefaultKeyID.
+func WithKeyID(keyID string) IssuerOption {
+	return func(c *issuerConfig) {
+		c.keyID = keyID
+	}
+}
+
+// WithClock sets the clock used by both the Signer and the Verifier.
+func WithClock(clock ssdjwtauth.Clock) IssuerOption {
+	return func(c *issuerConfig) {
+		c.clock = clock
+	}
+}
+
+// WithSignerOptions passes options to ssdjwtauth.NewSigner.
+func WithSignerOptions(opts ...ssdjwtauth.SignerOption) IssuerOption {
+	return func(c *issuerConfig) {
+		c.signerOptions = append(c.signerOptions, opts...)
+	}
+}
+
+// WithVerifierOptions passes options to ssdjwtauth.NewVerifier.
+func WithVerifierOptions(opts ...ssdjwtauth.VerifierOption) IssuerOption {
+	return func(c *issuerConfig) {
+		c.verifierOptions = append(c.verifierOptions, opts...)
+	}
+}
+
+var (
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+	keyErr  error
+)
+
+// testKey returns an RSA key shared by all Issuers, as generating one for
+// every test is slow.
+func testKey() (*rsa.PrivateKey, error) {
+	keyOnce.Do(func() {
+		key, keyErr = rsa.GenerateKey(rand.Reader, 2048)
+	})
+	return key, keyErr
+}
+
+// NewIssuer creates an Issuer, failing the test if that is not possible.
+func NewIssuer(t testing.TB, opts ...IssuerOption) *Issuer {
+	t.Helper()
+	config := &issuerConfig{keyID: DefaultKeyID}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.clock != nil {
+		config.signerOptions = append(config.signerOptions, ssdjwtauth.WithSignerClock(config.clock))
+		config.verifierOptions = append(config.verifierOptions, ssdjwtauth.WithVerifierClock(config.clock))
+	}
+
+	k, err := testKey()
+	if err != nil {
+		t.Fatalf("ssdjwttest: generating key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+	if err != nil {
+		t.Fatalf("ssdjwttest: marshalling public key: %v", err)
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	signer, err := ssdjwtauth.NewSigner(config.keyID, privatePEM, config.signerOptions...)
+	if err != nil {
+		t.Fatalf("ssdjwttest: NewSigner: %v", err)
+	}
+	verifier, err := ssdjwtauth.NewVerifier(map[string][]byte{config.keyID: publicPEM}, nil, config.verifierOptions...)
+	if err != nil {
+		t.Fatalf("ssdjwttest: NewVerifier: %v", err)
+	}
+	return &Issuer{
+		Signer:       signer,
+		Verifier:     verifier,
+		KeyID:        config.keyID,
+		PublicKeyPEM: publicPEM,
+	}
+}
+
+// UserClaims returns valid user claims using the defaults.
+func UserClaims() ssdjwtauth.SSDClaims {
+	return ssdjwtauth.SSDClaims{
+		Type:   ssdjwtauth.SSDTokenTypeUser,
+		UserID: DefaultUserID,
+		OrgID:  DefaultOrgID,
+		Groups: []string{DefaultGroup},
+	}
+}
+
+// ServiceClaims returns valid service account claims using the defaults.
+func ServiceClaims() ssdjwtauth.SSDClaims {
+	return ssdjwtauth.SSDClaims{
+		Type:     ssdjwtauth.SSDTokenTypeService,
+		Service:  DefaultService,
+		Instance: DefaultInstance,
+		OrgID:    DefaultOrgID,
+	}
+}
+
+// InternalClaims returns valid internal account claims using the defaults.
+func InternalClaims(authorizations ...string) ssdjwtauth.SSDClaims {
+	return ssdjwtauth.SSDClaims{
+		Type:           ssdjwtauth.SSDTokenTypeInternal,
+		Service:        DefaultService,
+		Authorizations: authorizations,
+	}
+}
+
+// IntegrationClaims returns valid integration claims using the defaults.
+func IntegrationClaims() ssdjwtauth.SSDClaims {
+	return ssdjwtauth.SSDClaims{
+		Type:   ssdjwtauth.SSDTokenTypeIntegration,
+		TeamID: DefaultTeamID,
+		OrgID:  DefaultOrgID,
+	}
+}
+
+// Claims returns the full claims for a token carrying ssd, valid for
+// DefaultTokenExpiry from the Signer's current time, with a random jti.
+func (i *Issuer) Claims(ssd ssdjwtauth.SSDClaims) ssdjwtauth.SsdJwtClaims {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return i.Signer.NewClaims(DefaultTokenExpiry, base64.RawURLEncoding.EncodeToString(b), ssd)
+}
+
+// Token signs a token carrying ssd, failing the test if that is not possible.
+func (i *Issuer) Token(t testing.TB, ssd ssdjwtauth.SSDClaims) string {
+	t.Helper()
+	return i.Sign(t, i.Claims(ssd))
+}
+
+// Sign signs the claims, failing the test if that is not possible.
+func (i *Issuer) Sign(t testing.TB, claims ssdjwtauth.SsdJwtClaims) string {
+	t.Helper()
+	token, err := i.Signer.SignToken(claims)
+	if err != nil {
+		t.Fatalf("ssdjwttest: SignToken: %v", err)
+	}
+	return token
+}
+
+// UserToken returns a user token using the default claims.
+func (i *Issuer) UserToken(t testing.TB) string {
+	t.Helper()
+	return i.Token(t, UserClaims())
+}
+
+// ServiceToken returns a service account token using the default claims.
+func (i *Issuer) ServiceToken(t testing.TB) string {
+	t.Helper()
+	return i.Token(t, ServiceClaims())
+}
+
+// InternalToken returns an internal account token with the authorizations.
+func (i *Issuer) InternalToken(t testing.TB, authorizations ...string) string {
+	t.Helper()
+	return i.Token(t, InternalClaims(authorizations...))
+}
+
+// IntegrationToken returns an integration token using the default claims.
+func (i *Issuer) IntegrationToken(t testing.TB) string {
+	t.Helper()
+	return i.Token(t, IntegrationClaims())
+}
+
+// JWKSServer starts a server returning the Issuer's public keys as a JWK
+// set, at any path.  It is closed when the test finishes.
+func (i *Issuer) JWKSServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(i.Verifier.JWKKeys())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// AuthorizeRequest adds a bearer token carrying ssd to the request.
+func (i *Issuer) AuthorizeRequest(t testing.TB, r *http.Request, ssd ssdjwtauth.SSDClaims) {
+	t.Helper()
+	r.Header.Set("Authorization", "Bearer "+i.Token(t, ssd))
+}
+
+// WithToken returns a copy of the request carrying a token with the claims,
+// as passed on by the Verifier's middleware, so that handlers installed
+// behind the middleware can be tested on their own.
+func (i *Issuer) WithToken(t testing.TB, r *http.Request, ssd ssdjwtauth.SSDClaims) *http.Request {
+	t.Helper()
+	return i.WithClaims(t, r, i.Claims(ssd))
+}
+
+// WithClaims is WithToken for full claims, such as those from Claims with
+// the expiry changed.  The claims are signed and passed through the
+// middleware, which fails the test if it rejects them.
+func (i *Issuer) WithClaims(t testing.TB, r *http.Request, claims ssdjwtauth.SsdJwtClaims) *http.Request {
+	t.Helper()
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+i.Sign(t, claims))
+	var verified *http.Request
+	w := httptest.NewRecorder()
+	i.Verifier.MiddlewareFunc()(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		verified = r
+	})).ServeHTTP(w, r)
+	if verified == nil {
+		t.Fatalf("ssdjwttest: token rejected by the Verifier's middleware: %d %s", w.Code, w.Body.String())
+	}
+	return verified
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwttest
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+)
+
+func TestIssuerTokens(t *testing.T) {
+	issuer := NewIssuer(t)
+	tests := []struct {
+		name      string
+		token     string
+		wantType  string
+		principal string
+	}{
+		{"user", issuer.UserToken(t), ssdjwtauth.SSDTokenTypeUser, DefaultUserID},
+		{"service", issuer.ServiceToken(t), ssdjwtauth.SSDTokenTypeService, DefaultService},
+		{"internal", issuer.InternalToken(t, "artifacts:read"), ssdjwtauth.SSDTokenTypeInternal, DefaultService},
+		{"integration", issuer.IntegrationToken(t), ssdjwtauth.SSDTokenTypeIntegration, DefaultTeamID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := issuer.Verifier.VerifyToken(tt.token)
+			if err != nil {
+				t.Fatalf("VerifyToken: %v", err)
+			}
+			id, err := ssdjwtauth.IdentityFromClaims(claims)
+			if err != nil {
+				t.Fatalf("IdentityFromClaims: %v", err)
+			}
+			if id.Type() != tt.wantType || id.Principal() != tt.principal {
+				t.Errorf("identity = %s %s, want %s %s", id.Type(), id.Principal(), tt.wantType, tt.principal)
+			}
+		})
+	}
+
+	if a, b := issuer.UserToken(t), issuer.UserToken(t); a == b {
+		t.Errorf("tokens should have distinct jtis")
+	}
+}
+
+func TestIssuerMiddleware(t *testing.T) {
+	issuer := NewIssuer(t)
+	var gotUser string
+	handler := issuer.Verifier.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := ssdjwtauth.IdentityFromContext(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		gotUser = id.Principal()
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	user := UserClaims()
+	user.UserID = "bob"
+	issuer.AuthorizeRequest(t, r, user)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || gotUser != "bob" {
+		t.Errorf("status = %d, user = %q; want 200, bob", w.Code, gotUser)
+	}
+}
+
+func TestRequestContextHelpers(t *testing.T) {
+	issuer := NewIssuer(t)
+	admin := UserClaims()
+	admin.IsAdmin = true
+	handler := ssdjwtauth.RequireAdmin()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{"no claims", httptest.NewRequest("GET", "/", nil), http.StatusUnauthorized},
+		{"user", issuer.WithToken(t, httptest.NewRequest("GET", "/", nil), UserClaims()), http.StatusForbidden},
+		{"admin", issuer.WithToken(t, httptest.NewRequest("GET", "/", nil), admin), http.StatusOK},
+		{"admin claims", issuer.WithClaims(t, httptest.NewRequest("GET", "/", nil), issuer.Claims(admin)), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWKSServer(t *testing.T) {
+	issuer := NewIssuer(t, WithKeyID("kid-1"))
+	server := issuer.JWKSServer(t)
+
+	resp, err := http.Get(server.URL + "/.well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	var set ssdjwtauth.JWKWrapper
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		t.Fatalf("decoding JWKS: %v", err)
+	}
+	if len(set.Keys) != 1 || set.Keys[0].KID != "kid-1" {
+		t.Fatalf("keys = %+v, want one key kid-1", set.Keys)
+	}
+	served, err := set.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	key, _ := issuer.Verifier.Key("kid-1")
+	if !served.(*rsa.PublicKey).Equal(key) {
+		t.Errorf("served key does not match the issuer's key")
+	}
+}
+
+func TestIssuerClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))
+	issuer := NewIssuer(t, WithClock(clock))
+	token := issuer.UserToken(t)
+	if _, err := issuer.Verifier.VerifyToken(token); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	clock.Advance(DefaultTokenExpiry + time.Hour)
+	if _, err := issuer.Verifier.VerifyToken(token); err == nil {
+		t.Errorf("expected token to have expired")
+	}
+}
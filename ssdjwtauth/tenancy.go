@@ -0,0 +1,227 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// TenantExtractor returns the tenant a request is addressed to, taken from
+// the route, a header or the host.
+type TenantExtractor func(r *http.Request) (string, error)
+
+// TenantLevel selects which claim a tenant is compared against.
+type TenantLevel int
+
+const (
+	TenantOrg TenantLevel = iota
+	TenantTeam
+)
+
+func (l TenantLevel) String() string {
+	if l == TenantTeam {
+		return "team"
+	}
+	return "organization"
+}
+
+// TenancyOptions control which tokens may cross tenant boundaries.
+type TenancyOptions struct {
+	// Level selects whether the tenant is an orgID or a teamID.  Team IDs
+	// are unique only within an organization, so a team tenant is written
+	// "<orgID>/<teamID>" and both must match the token.
+	Level TenantLevel
+	// Org extracts the organization of a team tenant, and is required by
+	// RequireTenant when Level is TenantTeam.  The TenantExtractor passed
+	// to RequireTenant then extracts the team.
+	Org TenantExtractor
+	// AllowAdmin lets tokens with isAdmin set access any tenant.
+	AllowAdmin bool
+	// AllowInternal lets internal-account tokens, which carry no tenant,
+	// access any tenant.  If InternalAuthorization is also set, the token
+	// must hold that authorization, with "{tenant}" replaced by the tenant,
+	// such as "org/{tenant}:access".
+	AllowInternal         bool
+	InternalAuthorization string
+}
+
+type tenantContextKeyType int
+
+var tenantContextKey tenantContextKeyType
+
+// TenantFromHeader extracts the tenant from a request header.
+func TenantFromHeader(name string) TenantExtractor {
+	return func(r *http.Request) (string, error) {
+		tenant := r.Header.Get(name)
+		if tenant == "" {
+			return "", fmt.Errorf("header %s is not set", name)
+		}
+		return tenant, nil
+	}
+}
+
+// TenantFromHost extracts the tenant from the first label of the host
+// name, where the rest of the host must be the suffix, such as
+// "ssd.example.com" for "org1.ssd.example.com".  Host names are not case
+// sensitive, so the tenant is returned in lower case.
+func TenantFromHost(suffix string) TenantExtractor {
+	suffix = "." + strings.TrimPrefix(suffix, ".")
+	return func(r *http.Request) (string, error) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		tenant, found := strings.CutSuffix(strings.ToLower(host), strings.ToLower(suffix))
+		if !found || tenant == "" || strings.Contains(tenant, ".") {
+			return "", fmt.Errorf("host %s is not a tenant of %s", host, suffix[1:])
+		}
+		return tenant, nil
+	}
+}
+
+// TenantFromPath extracts the tenant from the URL path, using a pattern
+// with one variable segment, such as "/api/v1/orgs/{org}".  The pattern
+// matches a prefix of the cleaned path, so it need not describe the whole
+// route.
+func TenantFromPath(pattern string) TenantExtractor {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	index := -1
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if index >= 0 {
+				index = -2
+				break
+			}
+			index = i
+		}
+	}
+	return func(r *http.Request) (string, error) {
+		if index < 0 {
+			return "", fmt.Errorf("path pattern %s must have exactly one {variable}", pattern)
+		}
+		// clean the path, as Policy.MiddlewareFunc does, so that
+		// "/orgs/mine/../other" is not taken to be addressed to "mine"
+		parts := strings.Split(strings.Trim(path.Clean("/"+r.URL.Path), "/"), "/")
+		if len(parts) < len(segments) {
+			return "", fmt.Errorf("path %s does not match %s", r.URL.Path, pattern)
+		}
+		for i, segment := range segments {
+			if i != index && parts[i] != segment {
+				return "", fmt.Errorf("path %s does not match %s", r.URL.Path, pattern)
+			}
+		}
+		if parts[index] == "" {
+			return "", fmt.Errorf("path %s has an empty tenant", r.URL.Path)
+		}
+		return parts[index], nil
+	}
+}
+
+// CheckTenant returns an error if the claims do not permit access to the
+// tenant.
+func CheckTenant(claims *SsdJwtClaims, tenant string, opts TenancyOptions) error {
+	if claims == nil {
+		return fmt.Errorf("no claims")
+	}
+	c := &claims.SSDCLaims
+	if tenant == "" {
+		return fmt.Errorf("no %s in request", opts.Level)
+	}
+	org, team := tenant, ""
+	if opts.Level == TenantTeam {
+		var found bool
+		org, team, found = strings.Cut(tenant, "/")
+		if !found {
+			return fmt.Errorf("team %q is not of the form <orgID>/<teamID>", tenant)
+		}
+		if err := checkIdentifier("teamID", team); err != nil || team == "" {
+			return fmt.Errorf("invalid team %q", team)
+		}
+	}
+	if err := checkIdentifier("orgID", org); err != nil || org == "" {
+		return fmt.Errorf("invalid organization %q", org)
+	}
+
+	if c.OrgID != "" && c.OrgID == org && (opts.Level != TenantTeam || c.TeamID == team) {
+		return nil
+	}
+	if opts.AllowAdmin && c.IsAdmin {
+		return nil
+	}
+	if opts.AllowInternal && sameTokenType(c.Type, SSDTokenTypeInternal) {
+		if opts.InternalAuthorization == "" {
+			return nil
+		}
+		required := strings.ReplaceAll(opts.InternalAuthorization, "{tenant}", tenant)
+		if _, err := ParseScope(required); err == nil && c.HasAuthorization(required) {
+			return nil
+		}
+		return fmt.Errorf("authorization %q required", required)
+	}
+	return fmt.Errorf("token is not valid for %s %q", opts.Level, tenant)
+}
+
+// RequireTenant returns middleware which rejects requests whose token does
+// not belong to the tenant the request is addressed to.  It must be installed
+// after the Verifier's middleware.  Requests without a tenant are rejected
+// with 400, and requests for another tenant with 403.  The tenant is placed
+// in the request context, see TenantFromContext.
+func RequireTenant(extract TenantExtractor, opts TenancyOptions) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, found := SSDClaimsFromContext(r.Context())
+			if !found || claims == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthorized"))
+				return
+			}
+			if opts.Level == TenantTeam && opts.Org == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte("Internal Server Error"))
+				return
+			}
+			tenant, err := extract(r)
+			if err == nil && opts.Level == TenantTeam {
+				var org string
+				org, err = opts.Org(r)
+				tenant = org + "/" + tenant
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("Bad Request: " + err.Error()))
+				return
+			}
+			if err := CheckTenant(claims, tenant, opts); err != nil {
+				denyAudit(r.Context(), AuditForbidden)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Forbidden: " + err.Error()))
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), tenantContextKey, tenant))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// TenantFromContext returns the tenant placed in the context by RequireTenant.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(tenantContextKey).(string)
+	return v, ok
+}
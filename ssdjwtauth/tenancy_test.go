@@ -0,0 +1,175 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenantExtractors(t *testing.T) {
+	tests := []struct {
+		name    string
+		extract TenantExtractor
+		url     string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"header", TenantFromHeader("X-Org"), "http://x/", "org1", "org1", false},
+		{"header missing", TenantFromHeader("X-Org"), "http://x/", "", "", true},
+		{"host", TenantFromHost("ssd.example.com"), "http://org1.ssd.example.com:8080/", "", "org1", false},
+		{"host case", TenantFromHost(".ssd.example.com"), "http://ORG1.SSD.example.com/", "", "org1", false},
+		{"host nested", TenantFromHost("ssd.example.com"), "http://a.org1.ssd.example.com/", "", "", true},
+		{"host other domain", TenantFromHost("ssd.example.com"), "http://org1.example.org/", "", "", true},
+		{"host bare suffix", TenantFromHost("ssd.example.com"), "http://ssd.example.com/", "", "", true},
+		{"path", TenantFromPath("/api/v1/orgs/{org}"), "http://x/api/v1/orgs/org1/policies/3", "", "org1", false},
+		{"path exact", TenantFromPath("/api/v1/orgs/{org}"), "http://x/api/v1/orgs/org1", "", "org1", false},
+		{"path other route", TenantFromPath("/api/v1/orgs/{org}"), "http://x/api/v1/teams/org1", "", "", true},
+		{"path too short", TenantFromPath("/api/v1/orgs/{org}"), "http://x/api/v1/orgs", "", "", true},
+		{"path dot segments", TenantFromPath("/orgs/{org}"), "http://x/orgs/mine/../../orgs/other", "", "other", false},
+		{"path empty tenant", TenantFromPath("/orgs/{org}/x"), "http://x/orgs//x", "", "", true},
+		{"path no variable", TenantFromPath("/api/v1/orgs"), "http://x/api/v1/orgs", "", "", true},
+		{"path two variables", TenantFromPath("/orgs/{org}/teams/{team}"), "http://x/orgs/a/teams/b", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.url, nil)
+			if tt.header != "" {
+				r.Header.Set("X-Org", tt.header)
+			}
+			got, err := tt.extract(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extract() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("extract() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTenant(t *testing.T) {
+	user := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1"}}
+	admin := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, UserID: "root", OrgID: "org1", IsAdmin: true}}
+	integration := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "team1", OrgID: "org1"}}
+	internal := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", Authorizations: []string{"org/org2:access"}}}
+
+	tests := []struct {
+		name    string
+		claims  *SsdJwtClaims
+		tenant  string
+		opts    TenancyOptions
+		wantErr bool
+	}{
+		{"same org", user, "org1", TenancyOptions{}, false},
+		{"other org", user, "org2", TenancyOptions{}, true},
+		{"no tenant", user, "", TenancyOptions{}, true},
+		{"admin without override", admin, "org2", TenancyOptions{}, true},
+		{"admin with override", admin, "org2", TenancyOptions{AllowAdmin: true}, false},
+		{"org case", user, "ORG1", TenancyOptions{}, true},
+		{"invalid org", user, "org1/x", TenancyOptions{}, true},
+		{"same team", integration, "org1/team1", TenancyOptions{Level: TenantTeam}, false},
+		{"same team org case", integration, "Org1/team1", TenancyOptions{Level: TenantTeam}, true},
+		{"other team", integration, "org1/team2", TenancyOptions{Level: TenantTeam}, true},
+		{"same team in other org", integration, "org2/team1", TenancyOptions{Level: TenantTeam}, true},
+		{"team without org", integration, "team1", TenancyOptions{Level: TenantTeam}, true},
+		{"user has no team", user, "org1/team1", TenancyOptions{Level: TenantTeam}, true},
+		{"internal without override", internal, "org2", TenancyOptions{}, true},
+		{"internal with override", internal, "org3", TenancyOptions{AllowInternal: true}, false},
+		{"internal with authorization", internal, "org2", TenancyOptions{AllowInternal: true, InternalAuthorization: "org/{tenant}:access"}, false},
+		{"internal missing authorization", internal, "org3", TenancyOptions{AllowInternal: true, InternalAuthorization: "org/{tenant}:access"}, true},
+		{"internal tenant injects resource", internal, "org2/x", TenancyOptions{AllowInternal: true, InternalAuthorization: "org/{tenant}:access"}, true},
+		{"internal tenant injects action", internal, "org2:access", TenancyOptions{AllowInternal: true, InternalAuthorization: "org/{tenant}:access"}, true},
+		{"internal team covered by org", internal, "org2/team9", TenancyOptions{Level: TenantTeam, AllowInternal: true, InternalAuthorization: "org/{tenant}:access"}, false},
+		{"nil claims", nil, "org1", TenancyOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckTenant(tt.claims, tt.tenant, tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("CheckTenant() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRequireTenant(t *testing.T) {
+	user := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1"}}
+	var gotTenant string
+	handler := RequireTenant(TenantFromPath("/orgs/{org}"), TenancyOptions{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant, _ = TenantFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name       string
+		path       string
+		claims     *SsdJwtClaims
+		wantStatus int
+	}{
+		{"allowed", "/orgs/org1/things", user, http.StatusOK},
+		{"other tenant", "/orgs/org2/things", user, http.StatusForbidden},
+		{"no tenant", "/things", user, http.StatusBadRequest},
+		{"no claims", "/orgs/org1/things", nil, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotTenant = ""
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.claims != nil {
+				r = r.WithContext(contextWithToken(r.Context(), tt.claims, "token"))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantStatus == http.StatusOK && gotTenant != "org1" {
+				t.Errorf("expected tenant org1 in context, got %q", gotTenant)
+			}
+		})
+	}
+}
+
+func TestRequireTenant_team(t *testing.T) {
+	integration := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "team1", OrgID: "org1"}}
+	opts := TenancyOptions{Level: TenantTeam, Org: TenantFromPath("/orgs/{org}")}
+	handler := RequireTenant(TenantFromHeader("X-Team"), opts)(http.NotFoundHandler())
+	misconfigured := RequireTenant(TenantFromHeader("X-Team"), TenancyOptions{Level: TenantTeam})(http.NotFoundHandler())
+
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		path       string
+		wantStatus int
+	}{
+		{"allowed", handler, "/orgs/org1/things", http.StatusNotFound},
+		{"same team in other org", handler, "/orgs/org2/things", http.StatusForbidden},
+		{"no org", handler, "/things", http.StatusBadRequest},
+		{"no Org extractor", misconfigured, "/orgs/org1/things", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("X-Team", "team1")
+			r = r.WithContext(contextWithToken(r.Context(), integration, "token"))
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// WithTokenCache caches up to size verified tokens, so a token presented
+// repeatedly pays for signature verification only once.  Entries are keyed
+// by a SHA-256 hash of the token, kept until the token expires, and dropped
+// when the keys change.  Call InvalidateToken when revoking a token.
+// A size of zero or less disables the cache.
+func WithTokenCache(size int) VerifierOption {
+	return func(v *Verifier) {
+		if size <= 0 {
+			v.tokenCache = nil
+			return
+		}
+		v.tokenCache = &tokenCache{size: size}
+	}
+}
+
+// InvalidateToken removes the token from the verified-token cache, if any,
+// so that it is fully verified again the next time it is presented.
+func (v *Verifier) InvalidateToken(tokenString string) {
+	if v.tokenCache != nil {
+		v.tokenCache.remove(sha256.Sum256([]byte(tokenString)))
+	}
+}
+
+// tokenCache is a least recently used cache of verified claims.
+type tokenCache struct {
+	size int
+
+	mu         sync.Mutex
+	entries    map[[sha256.Size]byte]*list.Element
+	order      list.List
+	generation uint64
+}
+
+type tokenCacheEntry struct {
+	key    [sha256.Size]byte
+	claims *SsdJwtClaims
+	expiry time.Time
+}
+
+// get returns a copy of the cached claims for the token, and the cache
+// generation to pass to put if they were not found.
+func (c *tokenCache) get(key [sha256.Size]byte, now time.Time) (*SsdJwtClaims, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, found := c.entries[key]
+	if !found {
+		return nil, c.generation
+	}
+	entry := e.Value.(*tokenCacheEntry)
+	if !now.Before(entry.expiry) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return nil, c.generation
+	}
+	c.order.MoveToFront(e)
+	return entry.claims.clone(), c.generation
+}
+
+// put caches the claims unless the cache has been cleared since the
+// generation was read, as they may have been verified with a removed key.
+func (c *tokenCache) put(key [sha256.Size]byte, claims *SsdJwtClaims, expiry time.Time, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if c.entries == nil {
+		c.entries = map[[sha256.Size]byte]*list.Element{}
+	}
+	if e, found := c.entries[key]; found {
+		c.order.Remove(e)
+	}
+	for c.order.Len() >= c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*tokenCacheEntry).key)
+	}
+	c.entries[key] = c.order.PushFront(&tokenCacheEntry{key: key, claims: claims.clone(), expiry: expiry})
+}
+
+func (c *tokenCache) remove(key [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, found := c.entries[key]; found {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// clear empties the cache, and prevents verifications already in
+// progress from adding to it.
+func (c *tokenCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = nil
+	c.order.Init()
+	c.generation++
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTokenCache(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithTokenCache(2)(v)
+	token := signTestToken(t, s, testUserClaims)
+
+	claims, err := v.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	claims.SSDCLaims.Groups[0] = "modified"
+	cached, err := v.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("VerifyToken (cached): %v", err)
+	}
+	if cached.SSDCLaims.Groups[0] != "dev" {
+		t.Errorf("cached claims were modified by a caller: %v", cached.SSDCLaims.Groups)
+	}
+	if len(v.tokenCache.entries) != 1 {
+		t.Errorf("cache has %d entries, want 1", len(v.tokenCache.entries))
+	}
+
+	v.InvalidateToken(token)
+	if len(v.tokenCache.entries) != 0 {
+		t.Errorf("cache has %d entries after InvalidateToken, want 0", len(v.tokenCache.entries))
+	}
+
+	now := time.Now()
+	for _, id := range []string{"a", "b", "c"} {
+		token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), id, testUserClaims))
+		if err != nil {
+			t.Fatalf("SignToken: %v", err)
+		}
+		if _, err := v.VerifyToken(token); err != nil {
+			t.Fatalf("VerifyToken: %v", err)
+		}
+	}
+	if len(v.tokenCache.entries) != 2 {
+		t.Errorf("cache has %d entries, want the limit of 2", len(v.tokenCache.entries))
+	}
+}
+
+func TestTokenCacheKeyChange(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	WithTokenCache(10)(v)
+	token := signTestToken(t, s, testUserClaims)
+	if _, err := v.VerifyToken(token); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&other.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	err = v.SetKeys(map[string][]byte{"testkey": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})})
+	if err != nil {
+		t.Fatalf("SetKeys: %v", err)
+	}
+	if _, err := v.VerifyToken(token); err == nil {
+		t.Errorf("expected cached token to be rejected after its key was replaced")
+	}
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	now := time.Now()
+	timeFunc := TimeFunc(func() time.Time { return now })
+	s, _ := newTestSignerVerifier(t)
+	_, public := testKeyPEMs(t)
+	v, err := NewVerifier(map[string][]byte{"testkey": public}, &timeFunc, WithTokenCache(10))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Minute), "test-jti", testUserClaims))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	if _, err := v.VerifyToken(token); err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	now = now.Add(time.Minute + tokenLeeway)
+	if _, err := v.VerifyToken(token); err == nil {
+		t.Errorf("expected expired token to be rejected")
+	}
+	if len(v.tokenCache.entries) != 0 {
+		t.Errorf("expired entry was not removed from the cache")
+	}
+}
+
+func benchmarkVerifyToken(b *testing.B, options ...VerifierOption) {
+	s, v := newTestSignerVerifier(b)
+	for _, option := range options {
+		option(v)
+	}
+	token := signTestToken(b, s, testUserClaims)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := v.VerifyToken(token); err != nil {
+				b.Fatalf("VerifyToken: %v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkVerifyToken(b *testing.B) {
+	benchmarkVerifyToken(b)
+}
+
+func BenchmarkVerifyTokenCached(b *testing.B) {
+	benchmarkVerifyToken(b, WithTokenCache(1000))
+}
+
+func TestTokenCache_disabled(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		s, v := newTestSignerVerifier(t)
+		WithTokenCache(size)(v)
+		if v.tokenCache != nil {
+			t.Errorf("WithTokenCache(%d) enabled the cache", size)
+		}
+		if _, err := v.VerifyToken(signTestToken(t, s, testUserClaims)); err != nil {
+			t.Errorf("VerifyToken: %v", err)
+		}
+	}
+}
+
+func TestTokenCache_returnsCopies(t *testing.T) {
+	s, _ := newTestSignerVerifier(t)
+	now := time.Now()
+	claims := s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{
+		Type:           SSDTokenTypeInternal,
+		Service:        "svc",
+		Authorizations: []string{"read"},
+	})
+	claims.BindCertificate(newTestCertificate(t, "svc"))
+
+	tests := []struct {
+		name   string
+		modify func(c *SsdJwtClaims)
+	}{
+		{"authorizations", func(c *SsdJwtClaims) { c.SSDCLaims.Authorizations[0] = "write" }},
+		{"audience", func(c *SsdJwtClaims) { c.Audience[0] = "other" }},
+		{"expiry", func(c *SsdJwtClaims) { c.ExpiresAt.Time = c.ExpiresAt.Add(time.Hour) }},
+		{"confirmation", func(c *SsdJwtClaims) { c.Confirmation.X5TS256 = "other" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &tokenCache{size: 1}
+			key := sha256.Sum256([]byte("token"))
+			cache.put(key, claims.clone(), claims.ExpiresAt.Time, 0)
+			first, _ := cache.get(key, now)
+			tt.modify(first)
+			second, _ := cache.get(key, now)
+			if !reflect.DeepEqual(second, &claims) {
+				t.Errorf("cached claims were modified through a returned copy: %+v", second)
+			}
+		})
+	}
+}
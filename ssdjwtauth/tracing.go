@@ -0,0 +1,83 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/OpsMx/ssd-jwt-auth/ssdjwtauth"
+
+// Span attribute keys.
+const (
+	AttrTokenType    = attribute.Key("ssd.token.type")
+	AttrOrgID        = attribute.Key("ssd.org_id")
+	AttrPrincipal    = attribute.Key("ssd.principal")
+	AttrRejectReason = attribute.Key("ssd.reject_reason")
+	AttrKeyCount     = attribute.Key("ssd.key.count")
+)
+
+// TracingOptions configure the spans created by a Verifier.
+type TracingOptions struct {
+	// RecordPrincipal adds the principal of the verified identity, such as
+	// the user or service name, to spans.  It is off by default, as span
+	// attributes are often retained longer and more widely than logs.
+	RecordPrincipal bool
+}
+
+// WithTracing creates OpenTelemetry spans for request verification in the
+// middleware and for key reloads.  Without it, no spans are created.
+func WithTracing(tp trace.TracerProvider, opts TracingOptions) VerifierOption {
+	return func(v *Verifier) {
+		v.tracer = tp.Tracer(tracerName)
+		v.tracing = opts
+	}
+}
+
+// startSpan starts a span if tracing is configured, otherwise it returns
+// a span which records nothing.
+func (v *Verifier) startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if v.tracer == nil {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return v.tracer.Start(ctx, name, append(opts, trace.WithSpanKind(trace.SpanKindInternal))...)
+}
+
+// recordClaims adds attributes describing verified claims to the span.
+func (v *Verifier) recordClaims(span trace.Span, claims *SsdJwtClaims) {
+	if !span.IsRecording() {
+		return
+	}
+	span.SetAttributes(AttrTokenType.String(claims.SSDCLaims.Type))
+	if claims.SSDCLaims.OrgID != "" {
+		span.SetAttributes(AttrOrgID.String(claims.SSDCLaims.OrgID))
+	}
+	if v.tracing.RecordPrincipal {
+		if id, err := IdentityFromClaims(claims); err == nil {
+			span.SetAttributes(AttrPrincipal.String(id.Principal()))
+		}
+	}
+}
+
+// recordRejection marks the span as failed.  The error itself is not
+// recorded, as it may describe the token's claims.
+func recordRejection(span trace.Span, reason RejectReason) {
+	span.SetAttributes(AttrRejectReason.String(string(reason)))
+	span.SetStatus(codes.Error, "token rejected: "+string(reason))
+}
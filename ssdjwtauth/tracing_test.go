@@ -0,0 +1,294 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingTracer is a TracerProvider which records ended spans, so the
+// tests do not need the OpenTelemetry SDK.
+type recordingTracer struct {
+	embedded.TracerProvider
+
+	mu    sync.Mutex
+	ended []*recordedSpan
+}
+
+func (tp *recordingTracer) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return recordingTracerFor{tp: tp}
+}
+
+type recordingTracerFor struct {
+	embedded.Tracer
+	tp *recordingTracer
+}
+
+func (t recordingTracerFor) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return t.tp.Start(ctx, name, opts...)
+}
+
+func (tp *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &recordedSpan{tracer: tp, name: name, attrs: map[attribute.Key]string{}}
+	if !config.NewRoot() {
+		span.parent = trace.SpanContextFromContext(ctx)
+	}
+	traceID := span.parent.TraceID()
+	if !span.parent.IsValid() {
+		rand.Read(traceID[:])
+	}
+	var spanID trace.SpanID
+	rand.Read(spanID[:])
+	span.sc = trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID})
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+func (tp *recordingTracer) spans() []*recordedSpan {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	return append([]*recordedSpan{}, tp.ended...)
+}
+
+type recordedSpan struct {
+	noop.Span
+	tracer *recordingTracer
+	name   string
+	sc     trace.SpanContext
+	parent trace.SpanContext
+	attrs  map[attribute.Key]string
+	status codes.Code
+}
+
+func (s *recordedSpan) SpanContext() trace.SpanContext { return s.sc }
+func (s *recordedSpan) IsRecording() bool              { return true }
+
+func (s *recordedSpan) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value.Emit()
+	}
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, _ string) {
+	s.status = code
+}
+
+func (s *recordedSpan) End(...trace.SpanEndOption) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.ended = append(s.tracer.ended, s)
+}
+
+// spanContextHandler records the span in the context of each log record.
+type spanContextHandler struct {
+	slog.Handler
+	mu    sync.Mutex
+	spans map[string]trace.SpanContext
+}
+
+func (h *spanContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.spans[r.Message] = trace.SpanContextFromContext(ctx)
+	return nil
+}
+
+func TestMiddlewareTracing(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	token := signTestToken(t, s, testUserClaims)
+
+	tests := []struct {
+		name      string
+		opts      TracingOptions
+		token     string
+		wantAttrs map[attribute.Key]string
+		wantError bool
+	}{
+		{
+			"verified",
+			TracingOptions{},
+			token,
+			map[attribute.Key]string{AttrTokenType: SSDTokenTypeUser, AttrOrgID: "org1"},
+			false,
+		},
+		{
+			"with principal",
+			TracingOptions{RecordPrincipal: true},
+			token,
+			map[attribute.Key]string{AttrTokenType: SSDTokenTypeUser, AttrOrgID: "org1", AttrPrincipal: "alice"},
+			false,
+		},
+		{
+			"rejected",
+			TracingOptions{RecordPrincipal: true},
+			"garbage",
+			map[attribute.Key]string{AttrRejectReason: string(RejectMalformed)},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := &recordingTracer{}
+			WithTracing(tp, tt.opts)(v)
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			spans := tp.spans()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			if spans[0].name != "ssdjwtauth.VerifyRequest" {
+				t.Errorf("span name = %s", spans[0].name)
+			}
+			attrs := spans[0].attrs
+			if len(attrs) != len(tt.wantAttrs) {
+				t.Errorf("attributes = %v, want %v", attrs, tt.wantAttrs)
+			}
+			for k, want := range tt.wantAttrs {
+				if attrs[k] != want {
+					t.Errorf("attribute %s = %q, want %q", k, attrs[k], want)
+				}
+			}
+			if got := spans[0].status == codes.Error; got != tt.wantError {
+				t.Errorf("span error = %v, want %v", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestReloadKeysTracing(t *testing.T) {
+	_, public := testKeyPEMs(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "testkey"), public, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	tp := &recordingTracer{}
+	v, err := NewVerifier(nil, nil, WithTracing(tp, TracingOptions{}))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	if err := v.reloadKeyFiles(context.Background(), dir); err != nil {
+		t.Fatalf("reloadKeyFiles: %v", err)
+	}
+	v.reloadKeyFiles(context.Background(), filepath.Join(dir, "missing"))
+
+	spans := tp.spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if got := spans[0].attrs[AttrKeyCount]; got != "1" {
+		t.Errorf("key count = %q, want 1", got)
+	}
+	if spans[1].status != codes.Error {
+		t.Errorf("failed reload span status = %v, want error", spans[1].status)
+	}
+}
+
+func TestMiddlewareTracing_logContext(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	tp := &recordingTracer{}
+	logs := &spanContextHandler{Handler: slog.NewTextHandler(nil, &slog.HandlerOptions{Level: slog.LevelDebug}), spans: map[string]trace.SpanContext{}}
+	WithTracing(tp, TracingOptions{})(v)
+	WithVerifierLogger(slog.New(logs))(v)
+
+	var handlerSpan trace.SpanContext
+	handler := v.MiddlewareFunc()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestToken(t, s, testUserClaims))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := tp.spans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := logs.spans["token verified"]; !got.Equal(spans[0].sc) {
+		t.Errorf("log span = %v, want the verification span %v", got, spans[0].sc)
+	}
+	if handlerSpan.Equal(spans[0].sc) {
+		t.Errorf("handler context carries the ended verification span")
+	}
+}
+
+// manualClock ticks only when the test sends on its channel.
+type manualClock struct {
+	SystemClock
+	c chan time.Time
+}
+
+func (c manualClock) NewTicker(time.Duration) Ticker { return c }
+func (c manualClock) C() <-chan time.Time            { return c.c }
+func (c manualClock) Stop()                          {}
+
+func TestMaintainKeysTracing(t *testing.T) {
+	_, public := testKeyPEMs(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "testkey"), public, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	tp := &recordingTracer{}
+	clock := manualClock{c: make(chan time.Time)}
+	v, err := NewVerifier(nil, nil, WithTracing(tp, TracingOptions{}), WithVerifierClock(clock))
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+
+	ctx, parent := tp.Start(context.Background(), "startup")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := v.MaintainKeys(ctx, dir); err != nil {
+		t.Fatalf("MaintainKeys: %v", err)
+	}
+	parent.End()
+	clock.c <- time.Now()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(tp.spans()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the reload span")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	spans := tp.spans()
+	startup := parent.SpanContext()
+	if !spans[0].parent.Equal(startup) {
+		t.Errorf("initial load parent = %v, want the caller's span", spans[0].parent)
+	}
+	tick := spans[2]
+	if tick.name != "ssdjwtauth.ReloadKeys" || tick.parent.IsValid() || tick.sc.TraceID() == startup.TraceID() {
+		t.Errorf("reload on tick should start a new trace, got parent %v trace %v", tick.parent, tick.sc.TraceID())
+	}
+}
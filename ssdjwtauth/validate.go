@@ -0,0 +1,172 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"slices"
+	"unicode"
+)
+
+const (
+	maxClaimLength = 256
+	maxUserGroups  = 1000
+)
+
+// claimField describes how to validate one field of SSDClaims.
+type claimField struct {
+	name  string
+	isSet func(c *SSDClaims) bool
+	// check validates the characters and length of the field, when set.
+	check func(c *SSDClaims) error
+}
+
+// claimRules are the per-type validation rules.  Each type forbids every
+// field which its type-specific struct (such as SSDUserClaims) does not
+// have, and fields which are neither required nor forbidden are optional.
+type claimRules struct {
+	required  []string
+	forbidden []string
+	maxGroups int
+}
+
+var claimFields = []claimField{
+	{"groups", func(c *SSDClaims) bool { return len(c.Groups) > 0 }, func(c *SSDClaims) error {
+		for _, g := range c.Groups {
+			if err := checkPrintable("groups", g); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"isAdmin", func(c *SSDClaims) bool { return c.IsAdmin }, nil},
+	{"authorizations", func(c *SSDClaims) bool { return len(c.Authorizations) > 0 }, func(c *SSDClaims) error {
+		for _, a := range c.Authorizations {
+			if _, err := ParseScope(a); err != nil {
+				return fmt.Errorf("field authorizations: %v", err)
+			}
+		}
+		return nil
+	}},
+	{"orgID", func(c *SSDClaims) bool { return c.OrgID != "" }, func(c *SSDClaims) error { return checkIdentifier("orgID", c.OrgID) }},
+	{"userID", func(c *SSDClaims) bool { return c.UserID != "" }, func(c *SSDClaims) error { return checkPrintable("userID", c.UserID) }},
+	{"service", func(c *SSDClaims) bool { return c.Service != "" }, func(c *SSDClaims) error { return checkIdentifier("service", c.Service) }},
+	{"instance", func(c *SSDClaims) bool { return c.Instance != "" }, func(c *SSDClaims) error { return checkIdentifier("instance", c.Instance) }},
+	{"teamID", func(c *SSDClaims) bool { return c.TeamID != "" }, func(c *SSDClaims) error { return checkIdentifier("teamID", c.TeamID) }},
+	{"groupRef", func(c *SSDClaims) bool { return c.GroupRef != "" }, func(c *SSDClaims) error { return checkIdentifier("groupRef", c.GroupRef) }},
+}
+
+var claimRulesByType = map[string]claimRules{
+	SSDTokenTypeUser: {
+		required:  []string{"userID", "orgID"},
+		forbidden: []string{"authorizations", "service", "instance", "teamID"},
+		maxGroups: maxUserGroups,
+	},
+	SSDTokenTypeService: {
+		required:  []string{"service", "instance", "orgID"},
+		forbidden: []string{"groups", "groupRef", "isAdmin", "authorizations", "userID", "teamID"},
+	},
+	SSDTokenTypeInternal: {
+		required:  []string{"service"},
+		forbidden: []string{"groups", "groupRef", "isAdmin", "orgID", "userID", "instance", "teamID"},
+	},
+	SSDTokenTypeIntegration: {
+		required:  []string{"teamID", "orgID"},
+		forbidden: []string{"groups", "groupRef", "isAdmin", "authorizations", "userID", "service", "instance"},
+	},
+}
+
+// ValidateSSDClaims checks the claims against the rules for their type and
+// version (see RegisterTokenVersion), including the characters and length
+// of each field.  It is applied when claims are issued, when tokens are
+// verified, and when claims are converted to or from the type-specific
+// structs, so tokens issued with values these rules now forbid, such as a
+// flat "admin" authorization, no longer verify.
+func ValidateSSDClaims(c *SSDClaims) error {
+	tv, found := registry.version(c.Type)
+	if !found {
+		return fmt.Errorf("unknown token type %s", c.Type)
+	}
+	return tv.Validate(c)
+}
+
+// checkFieldValues checks the characters and length of each field which is set.
+func checkFieldValues(c *SSDClaims) error {
+	for _, field := range claimFields {
+		if field.check != nil && field.isSet(c) {
+			if err := field.check(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rules claimRules) validate(c *SSDClaims) error {
+	for _, field := range claimFields {
+		set := field.isSet(c)
+		if !set && slices.Contains(rules.required, field.name) {
+			return fmt.Errorf("required field %s is not set in claims", field.name)
+		}
+		if set && slices.Contains(rules.forbidden, field.name) {
+			return fmt.Errorf("field %s is not allowed in %s claims", field.name, c.Type)
+		}
+	}
+	if len(c.Groups) > 0 && c.GroupRef != "" {
+		return fmt.Errorf("fields groups and groupRef cannot both be set")
+	}
+	if len(c.Groups) > rules.maxGroups && !slices.Contains(rules.forbidden, "groups") {
+		return fmt.Errorf("too many groups: %d, limit is %d", len(c.Groups), rules.maxGroups)
+	}
+	return checkFieldValues(c)
+}
+
+// checkIdentifier allows letters, digits, '.', '_', ':' and '-',
+// starting with a letter or digit.
+func checkIdentifier(name string, value string) error {
+	if len(value) > maxClaimLength {
+		return fmt.Errorf("field %s is longer than %d characters", name, maxClaimLength)
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if alphanumeric(c) || (i > 0 && (c == '.' || c == '_' || c == ':' || c == '-')) {
+			continue
+		}
+		return fmt.Errorf("field %s contains invalid characters", name)
+	}
+	return nil
+}
+
+// checkPrintable allows any printable characters except leading or
+// trailing whitespace, for values such as user names and group names
+// which come from external identity providers.
+func checkPrintable(name string, value string) error {
+	if value == "" {
+		return fmt.Errorf("field %s contains an empty value", name)
+	}
+	if len(value) > maxClaimLength {
+		return fmt.Errorf("field %s is longer than %d characters", name, maxClaimLength)
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("field %s contains invalid characters", name)
+		}
+	}
+	runes := []rune(value)
+	if unicode.IsSpace(runes[0]) || unicode.IsSpace(runes[len(runes)-1]) {
+		return fmt.Errorf("field %s has leading or trailing whitespace", name)
+	}
+	return nil
+}
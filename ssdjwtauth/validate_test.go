@@ -0,0 +1,164 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateSSDClaims(t *testing.T) {
+	manyGroups := make([]string, maxUserGroups+1)
+	for i := range manyGroups {
+		manyGroups[i] = "group"
+	}
+	tests := []struct {
+		name    string
+		claims  SSDClaims
+		wantErr string
+	}{
+		{"valid user", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice@example.com", OrgID: "org-1", Groups: []string{"cn=dev team,ou=groups"}, IsAdmin: true}, ""},
+		{"user missing userID", SSDClaims{Type: SSDTokenTypeUser, OrgID: "org1"}, "required field userID"},
+		{"user missing orgID", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice"}, "required field orgID"},
+		{"user with authorizations", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", Authorizations: []string{"a:b"}}, "field authorizations is not allowed"},
+		{"user with teamID", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", TeamID: "team1"}, "field teamID is not allowed"},
+		{"user too many groups", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", Groups: manyGroups}, "too many groups"},
+		{"user empty group", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", Groups: []string{""}}, "field groups contains an empty value"},
+		{"user control character", SSDClaims{Type: SSDTokenTypeUser, UserID: "al\nice", OrgID: "org1"}, "field userID contains invalid characters"},
+		{"user whitespace", SSDClaims{Type: SSDTokenTypeUser, UserID: " alice", OrgID: "org1"}, "field userID has leading or trailing whitespace"},
+		{"user long userID", SSDClaims{Type: SSDTokenTypeUser, UserID: strings.Repeat("a", maxClaimLength+1), OrgID: "org1"}, "field userID is longer"},
+		{"user bad orgID", SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org/1"}, "field orgID contains invalid characters"},
+		{"valid service", SSDClaims{Type: SSDTokenTypeService, Service: "ssd-gate", Instance: "pod-1.x", OrgID: "org1"}, ""},
+		{"service missing instance", SSDClaims{Type: SSDTokenTypeService, Service: "ssd-gate", OrgID: "org1"}, "required field instance"},
+		{"service admin", SSDClaims{Type: SSDTokenTypeService, Service: "ssd-gate", Instance: "i", OrgID: "org1", IsAdmin: true}, "field isAdmin is not allowed"},
+		{"service with teamID", SSDClaims{Type: SSDTokenTypeService, Service: "ssd-gate", Instance: "i", OrgID: "org1", TeamID: "team1"}, "field teamID is not allowed"},
+		{"valid internal", SSDClaims{Type: SSDTokenTypeInternal, Service: "ssd-gate", Authorizations: []string{"artifacts:*"}}, ""},
+		{"internal missing service", SSDClaims{Type: SSDTokenTypeInternal, Authorizations: []string{"artifacts:*"}}, "required field service"},
+		{"internal flat authorization", SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", Authorizations: []string{"everything"}}, "field authorizations"},
+		{"internal groups", SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", Groups: []string{"dev"}}, "field groups is not allowed"},
+		{"internal with orgID", SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", OrgID: "org1"}, "field orgID is not allowed"},
+		{"internal with teamID", SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", TeamID: "team1"}, "field teamID is not allowed"},
+		{"internal with instance", SSDClaims{Type: SSDTokenTypeInternal, Service: "svc", Instance: "i"}, "field instance is not allowed"},
+		{"valid integration", SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "team1", OrgID: "org1"}, ""},
+		{"integration admin", SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "team1", OrgID: "org1", IsAdmin: true}, "field isAdmin is not allowed"},
+		{"integration missing teamID", SSDClaims{Type: SSDTokenTypeIntegration, OrgID: "org1"}, "required field teamID"},
+		{"integration identifier starts with dash", SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "-team", OrgID: "org1"}, "field teamID contains invalid characters"},
+		{"unknown type", SSDClaims{Type: "robot/v1"}, "unknown token type"},
+		{"no type", SSDClaims{}, "unknown token type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSSDClaims(&tt.claims)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateSSDClaims() unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateSSDClaims() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClaimsConversion_validates(t *testing.T) {
+	tests := []struct {
+		name string
+		to   func() (SSDClaims, error)
+		from func(*SsdJwtClaims) error
+	}{
+		{
+			"user",
+			func() (SSDClaims, error) { return SSDUserClaimsToClaims(&SSDUserClaims{UserID: "alice"}) },
+			func(s *SsdJwtClaims) error { _, err := SSDUserClaimsFromClaims(s); return err },
+		},
+		{
+			"service",
+			func() (SSDClaims, error) { return SSDServiceClaimsToClaims(&SSDServiceClaims{Service: "svc"}) },
+			func(s *SsdJwtClaims) error { _, err := SSDServiceClaimsFromClaims(s); return err },
+		},
+		{
+			"internal",
+			func() (SSDClaims, error) { return SSDInternalClaimsToClaims(&SSDInternalClaims{}) },
+			func(s *SsdJwtClaims) error { _, err := SSDInternalClaimsFromClaims(s); return err },
+		},
+		{
+			"integration",
+			func() (SSDClaims, error) { return SSDIntegrationClaimsToClaims(&SSDIntegrationClaims{OrgID: "org1"}) },
+			func(s *SsdJwtClaims) error { _, err := SSDIntegrationClaimsFromClaims(s); return err },
+		},
+	}
+	types := map[string]string{
+		"user":        SSDTokenTypeUser,
+		"service":     SSDTokenTypeService,
+		"internal":    SSDTokenTypeInternal,
+		"integration": SSDTokenTypeIntegration,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.to(); err == nil {
+				t.Errorf("expected issuing incomplete claims to fail")
+			}
+			s := &SsdJwtClaims{SSDCLaims: SSDClaims{Type: types[tt.name]}}
+			if err := tt.from(s); err == nil {
+				t.Errorf("expected parsing incomplete claims to fail")
+			}
+		})
+	}
+}
+
+func TestSignAndVerify_validates(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	bad := SSDClaims{Type: SSDTokenTypeIntegration, TeamID: "team1", OrgID: "org1", IsAdmin: true}
+	now := time.Now()
+	claims := s.MakeClaims(now, now.Add(time.Hour), "id", bad)
+	if _, err := s.SignToken(claims); err == nil {
+		t.Errorf("expected SignToken to reject an integration token with isAdmin")
+	}
+
+	// sign it anyway, bypassing the Signer, and ensure it is rejected on verify
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["kid"] = s.KeyID
+	tokenString, err := token.SignedString(s.Key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := v.VerifyToken(tokenString); err == nil {
+		t.Errorf("expected VerifyToken to reject an integration token with isAdmin")
+	}
+}
+
+func TestVerify_rejectsInvalidValues(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	bad := SSDClaims{Type: SSDTokenTypeInternal, Service: "bad service", Authorizations: []string{"admin"}}
+	now := time.Now()
+	claims := s.MakeClaims(now, now.Add(time.Hour), "id", bad)
+	if _, err := s.SignToken(claims); err == nil {
+		t.Errorf("expected SignToken to reject values which fail the character rules")
+	}
+
+	// the character rules apply on verify as well as on issue
+	token := jwt.NewWithClaims(signingMethod, claims)
+	token.Header["kid"] = s.KeyID
+	tokenString, err := token.SignedString(s.Key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := v.VerifyToken(tokenString); err == nil {
+		t.Errorf("expected VerifyToken to reject values which fail the character rules")
+	}
+}
@@ -17,41 +17,72 @@ package ssdjwtauth
 import (
 	"context"
 	"crypto"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"path"
-	"sync"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tokenLeeway = 5 * time.Minute
+
 var (
 	defaultParseOptions = []jwt.ParserOption{
-		jwt.WithLeeway(5 * time.Minute),
+		jwt.WithLeeway(tokenLeeway),
 		jwt.WithAudience(ssdTokenAudience),
 		jwt.WithExpirationRequired(),
 		jwt.WithIssuedAt(),
 		jwt.WithIssuer(ssdTokenIssuer),
+		// Reject non-canonical base64, so that a token cannot be altered
+		// by changing the unused bits of its last character.
+		jwt.WithStrictDecoding(),
 		jwt.WithValidMethods([]string{
 			signingMethod.Alg(),
 		})}
 )
 
 type Verifier struct {
-	sync.Mutex
-	Keys         map[string]crypto.PublicKey
-	parseOptions []jwt.ParserOption
+	// keys is replaced, never modified, so lookups need no lock.
+	keys          atomic.Pointer[keySet]
+	parseOptions  []jwt.ParserOption
+	clock         Clock
+	groupResolver GroupResolver
+	introspector  Introspector
+	dpop          DPoPOptions
+	dpopReplay    MemoryReplayCache
+	replayCache   ReplayCache
+	onceTypes     []string
+	tokenCache    *tokenCache
+	metrics       Metrics
+	log           *slog.Logger
+	tracer        trace.Tracer
+	tracing       TracingOptions
+	auditSink     AuditSink
+	lifetime      *LifetimePolicy
 }
 
+// VerifierOption configures optional Verifier behavior.
+type VerifierOption func(*Verifier)
+
 type TimeFunc func() time.Time
 
+// keySet is an immutable snapshot of the verification keys, by key id.
+type keySet map[string]crypto.PublicKey
+
 // Generate a new Signer from a list of keys, which are PEM-encoded keys,
 // mapped by key id.  If timeFunc is non-nil, it will be used to retrieve the
-// time during validation.
-func NewVerifier(pemkeys map[string][]byte, timeFunc *TimeFunc) (*Verifier, error) {
+// time during validation.  WithVerifierClock also controls the time used
+// by the Verifier's own caches and key maintenance.
+func NewVerifier(pemkeys map[string][]byte, timeFunc *TimeFunc, options ...VerifierOption) (*Verifier, error) {
 	keys, err := parseKeys(pemkeys)
 	if err != nil {
 		return nil, err
@@ -66,9 +97,15 @@ func NewVerifier(pemkeys map[string][]byte, timeFunc *TimeFunc) (*Verifier, erro
 	}
 
 	s := &Verifier{
-		Keys:         keys,
 		parseOptions: opts,
 	}
+	s.keys.Store(&keys)
+	if timeFunc != nil {
+		s.clock = timeFuncClock{now: *timeFunc}
+	}
+	for _, option := range options {
+		option(s)
+	}
 	return s, nil
 }
 
@@ -77,17 +114,44 @@ func (v *Verifier) SetKeys(pemkeys map[string][]byte) error {
 	if err != nil {
 		return err
 	}
-	v.Lock()
-	defer v.Unlock()
-	v.Keys = keys
+	v.storeKeys(keys)
 	return nil
 }
 
-func (v *Verifier) JWKKeys() []byte {
-	v.Lock()
-	defer v.Unlock()
+// storeKeys replaces the key set.  The token cache is cleared afterwards,
+// so it cannot keep tokens verified with a removed key.
+func (v *Verifier) storeKeys(keys keySet) {
+	v.keys.Store(&keys)
+	if v.tokenCache != nil {
+		v.tokenCache.clear()
+	}
+}
+
+func (v *Verifier) keySet() keySet {
+	if keys := v.keys.Load(); keys != nil {
+		return *keys
+	}
+	return nil
+}
+
+// Key returns the public key with the given key id.
+func (v *Verifier) Key(kid string) (crypto.PublicKey, bool) {
+	key, found := v.keySet()[kid]
+	return key, found
+}
+
+// KeyIDs returns the ids of the current keys, sorted.
+func (v *Verifier) KeyIDs() []string {
+	ids := []string{}
+	for id := range v.keySet() {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
 
-	jk := JWKFromKeymap(v.Keys)
+func (v *Verifier) JWKKeys() []byte {
+	jk := jwkFromKeymap(v.keySet(), v.logger())
 	b, err := json.Marshal(jk)
 	if err != nil {
 		return []byte{}
@@ -119,16 +183,25 @@ func alphanumeric(c byte) bool {
 	return ((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
 }
 
-func (v *Verifier) reloadKeyFiles(path string) error {
+func (v *Verifier) reloadKeyFiles(ctx context.Context, path string, opts ...trace.SpanStartOption) error {
+	ctx, span := v.startSpan(ctx, "ssdjwtauth.ReloadKeys", opts...)
+	defer span.End()
 	pemkeys, err := readKeyFiles(path)
+	if err == nil {
+		err = v.SetKeys(pemkeys)
+	}
+	v.getMetrics().KeysReloaded(err)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
-	return v.SetKeys(pemkeys)
+	span.SetAttributes(AttrKeyCount.Int(len(pemkeys)))
+	v.logger().DebugContext(ctx, "public keys loaded", "path", path, "count", len(pemkeys))
+	return nil
 }
 
 func (v *Verifier) MaintainKeys(ctx context.Context, path string) error {
-	err := v.reloadKeyFiles(path)
+	err := v.reloadKeyFiles(ctx, path)
 	if err != nil {
 		return err
 	}
@@ -139,23 +212,26 @@ func (v *Verifier) MaintainKeys(ctx context.Context, path string) error {
 }
 
 func (v *Verifier) maintain(ctx context.Context, path string) {
-	t := time.NewTicker(time.Second * 60)
+	t := clockOrSystem(v.clock).NewTicker(time.Second * 60)
+	defer t.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-t.C:
-			err := v.reloadKeyFiles(path)
+		case <-t.C():
+			// each reload is its own trace, rather than part of the one,
+			// if any, which started the maintenance and has long ended
+			err := v.reloadKeyFiles(ctx, path, trace.WithNewRoot())
 			if err != nil {
-				log.Printf("Error reloading public keys: %v", err)
+				v.logger().Error("unable to reload public keys", "path", path, "error", err)
 			}
 		}
 	}
 }
 
-func parseKeys(pemkeys map[string][]byte) (map[string]crypto.PublicKey, error) {
-	keys := map[string]crypto.PublicKey{}
+func parseKeys(pemkeys map[string][]byte) (keySet, error) {
+	keys := keySet{}
 
 	for name, pemstring := range pemkeys {
 		rk, err := jwt.ParseRSAPublicKeyFromPEM(pemstring)
@@ -167,45 +243,152 @@ func parseKeys(pemkeys map[string][]byte) (map[string]crypto.PublicKey, error) {
 	return keys, nil
 }
 
-// The key func will lock the validator while it searches for the key to return.
-// VerifyToken() should not attempt to acquire a lock, so the crypto step
-// occurs outside of a lock, allowing better parallelism.
+// VerifyToken verifies a token on its own.  Without the request it cannot
+// check that the sender holds the key a token is bound to, so it rejects
+// tokens bound to a DPoP key or a client certificate; use VerifyRequest,
+// or the middleware, for those.
+//
+// The key func looks up keys in an immutable snapshot, so verification
+// takes no lock and concurrent requests do not contend with each other
+// or with SetKeys.
 func (v *Verifier) VerifyToken(tokenString string) (*SsdJwtClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &SsdJwtClaims{}, v.KeyFunc(), v.parseOptions...)
+	claims, _, err := v.verify(nil, tokenString)
 	if err != nil {
-		log.Printf("Proceeding with Unverified token as ParseWithClaims gave error:%v", err)
-		// SRINI: Unable to get the Signature Verificatoin to work. For not turning it off
-		p := jwt.NewParser(v.parseOptions...)
-		token, _, err = p.ParseUnverified(tokenString, &SsdJwtClaims{})
-		if err != nil {
-			return nil, err
+		return nil, err
+	}
+	return claims, nil
+}
+
+// VerifyRequest verifies a token taken from the request, as the middleware
+// does, including any DPoP or client certificate binding.
+func (v *Verifier) VerifyRequest(r *http.Request, tokenString string) (*SsdJwtClaims, error) {
+	claims, _, err := v.verify(r, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verify records metrics and logs the outcome of verifyToken.  If the
+// error is found after the claims were verified, they are returned with
+// it.
+func (v *Verifier) verify(r *http.Request, tokenString string) (*SsdJwtClaims, RejectReason, error) {
+	start := time.Now()
+	ctx := context.Background()
+	if r != nil {
+		ctx = r.Context()
+	}
+	claims, reason, err := v.verifyToken(r, tokenString)
+	if err != nil {
+		tokenType := ""
+		if claims != nil {
+			tokenType = claims.SSDCLaims.Type
+		}
+		v.getMetrics().TokenRejected(reason, tokenType)
+		if l := v.logger(); l.Enabled(ctx, slog.LevelDebug) {
+			attrs := []any{"reason", reason, "error", err, "kid", unverifiedKeyID(tokenString)}
+			if claims != nil {
+				attrs = append(attrs, claimsAttrs(claims)...)
+			}
+			l.DebugContext(ctx, "token rejected", attrs...)
+		}
+		return claims, reason, err
+	}
+	v.getMetrics().TokenVerified(claims.SSDCLaims.Type, time.Since(start))
+	v.logger().DebugContext(ctx, "token verified", claimsAttrs(claims)...)
+	return claims, "", nil
+}
+
+// verifyToken returns an error if the token is not valid, or if r is not
+// made by the holder of a key the token is bound to.  If the error is found
+// after the claims were verified, they are returned with it.  A single-use
+// token is consumed last, so it is not used up by a request which fails
+// any other check.
+func (v *Verifier) verifyToken(r *http.Request, tokenString string) (*SsdJwtClaims, RejectReason, error) {
+	var claims *SsdJwtClaims
+	var err error
+	if IsReferenceToken(tokenString) {
+		ctx := context.Background()
+		if r != nil {
+			ctx = r.Context()
+		}
+		claims, err = v.verifyReferenceToken(ctx, tokenString)
+	} else {
+		claims, err = v.verifyJWT(tokenString)
+	}
+	if err != nil {
+		return nil, rejectReason(err), err
+	}
+	if err := v.checkLifetime(claims); err != nil {
+		return claims, rejectReason(err), err
+	}
+	if err := v.checkDPoP(r, tokenString, claims); err != nil {
+		return claims, RejectDPoP, err
+	}
+	if err := checkCertificateBinding(r, claims); err != nil {
+		return claims, RejectCertificate, err
+	}
+	if err := v.checkReplay(claims); err != nil {
+		return claims, rejectReason(err), err
+	}
+	return claims, "", nil
+}
+
+// verifyJWT checks the signature and claims of the token, unless it is
+// found in the verified-token cache.
+func (v *Verifier) verifyJWT(tokenString string) (*SsdJwtClaims, error) {
+	var key [sha256.Size]byte
+	var generation uint64
+	if v.tokenCache != nil {
+		key = sha256.Sum256([]byte(tokenString))
+		var claims *SsdJwtClaims
+		claims, generation = v.tokenCache.get(key, v.now())
+		v.getMetrics().TokenCacheLookup(claims != nil)
+		if claims != nil {
+			return claims, nil
 		}
-		// return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &SsdJwtClaims{}, v.KeyFunc(), v.parseOptions...)
+	if err != nil {
+		return nil, err
 	}
 	claims, ok := token.Claims.(*SsdJwtClaims)
 	if !ok {
 		return nil, fmt.Errorf("token is missing SSD claims")
 	}
+	if err := v.expandGroups(&claims.SSDCLaims); err != nil {
+		return nil, err
+	}
+	if err := UpgradeSSDClaims(&claims.SSDCLaims); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidSSDClaims, err)
+	}
+	if v.tokenCache != nil {
+		v.tokenCache.put(key, claims, claims.ExpiresAt.Add(tokenLeeway), generation)
+	}
 	return claims, nil
 }
 
 func (v *Verifier) KeyFunc() jwt.Keyfunc {
 	return func(token *jwt.Token) (interface{}, error) {
-		v.Lock()
-		defer v.Unlock()
-		kidi, found := token.Header["kid"]
-		if !found {
-			return nil, fmt.Errorf("no `kid` in header")
-		}
-		kid, ok := kidi.(string)
-		if !ok {
-			return nil, fmt.Errorf("cannot convert `kid` to string")
-		}
-		key, found := v.Keys[kid]
-		if !found {
-			return nil, fmt.Errorf("no such key %s", kid)
-		}
+		return v.keyForToken(token)
+	}
+}
 
-		return key, nil
+func (v *Verifier) keyForToken(token *jwt.Token) (crypto.PublicKey, error) {
+	kidi, found := token.Header["kid"]
+	if !found {
+		return nil, fmt.Errorf("no `kid` in header")
 	}
+	kid, ok := kidi.(string)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert `kid` to string")
+	}
+	key, found := v.Key(kid)
+	if !found {
+		// the kid is not yet verified, and the error may be logged
+		return nil, fmt.Errorf("no such key %q", truncateKeyID(kid))
+	}
+
+	return key, nil
 }
@@ -15,9 +15,16 @@
 package ssdjwtauth
 
 import (
-	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -83,6 +90,81 @@ func Test_SetKeys(t *testing.T) {
 	}
 }
 
+func generateTestKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	pemkey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PUBLIC KEY",
+		Bytes: x509.MarshalPKCS1PublicKey(&key.PublicKey),
+	})
+	return key, pemkey
+}
+
+func TestVerifier_VerifyToken(t *testing.T) {
+	key, pemkey := generateTestKey(t)
+	otherKey, _ := generateTestKey(t)
+
+	now := time.Now()
+	claims := SsdJwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ssdTokenIssuer,
+			Audience:  []string{ssdTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		SSDCLaims: SSDClaims{
+			Type:   SSDTokenTypeUser,
+			UserID: "alice",
+			OrgID:  "org1",
+		},
+	}
+	sign := func(kid string, key *rsa.PrivateKey) string {
+		token := jwt.NewWithClaims(signingMethod, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("unable to sign token: %v", err)
+		}
+		return s
+	}
+	valid := sign("key1", key)
+	parts := strings.Split(valid, ".")
+	tampered := strings.Join([]string{parts[0], parts[1], strings.Repeat("A", len(parts[2]))}, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid signature", valid, false},
+		{"signed by another key", sign("key1", otherKey), true},
+		{"unknown key id", sign("key99", key), true},
+		{"altered signature", tampered, true},
+		{"no signature", parts[0] + "." + parts[1] + ".", true},
+		{"non-canonical signature encoding", nonCanonicalToken(valid), true},
+	}
+
+	v, err := NewVerifier(map[string][]byte{"key1": pemkey}, nil)
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.VerifyToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && got != nil {
+				t.Errorf("VerifyToken() returned claims for an invalid token")
+			}
+		})
+	}
+}
+
 func TestVerifier_KeyFunc(t *testing.T) {
 	type args struct {
 		Token *jwt.Token
@@ -139,12 +221,11 @@ func TestVerifier_KeyFunc(t *testing.T) {
 		},
 	}
 
-	v := &Verifier{
-		Keys: map[string]crypto.PublicKey{
-			"key1": []byte("key1 contents"),
-			"key2": []byte("key2 contents"),
-		},
-	}
+	v := &Verifier{}
+	v.storeKeys(keySet{
+		"key1": []byte("key1 contents"),
+		"key2": []byte("key2 contents"),
+	})
 
 	keyfunc := v.KeyFunc()
 
@@ -161,6 +242,40 @@ func TestVerifier_KeyFunc(t *testing.T) {
 	}
 }
 
+func TestVerifier_KeyFunc_unknownKeyError(t *testing.T) {
+	v := &Verifier{}
+	v.storeKeys(keySet{})
+	kid := strings.Repeat("x", 100) + "\n" + strings.Repeat("y", 1000)
+	_, err := v.KeyFunc()(&jwt.Token{Header: map[string]interface{}{"kid": kid}})
+	if err == nil {
+		t.Fatalf("expected an error for an unknown key")
+	}
+	want := fmt.Sprintf("no such key %q", strings.Repeat("x", maxLoggedKeyID)+"...")
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func Test_truncateKeyID(t *testing.T) {
+	tests := []struct {
+		name string
+		kid  string
+		want string
+	}{
+		{"short", "key1", "key1"},
+		{"limit", strings.Repeat("k", maxLoggedKeyID), strings.Repeat("k", maxLoggedKeyID)},
+		{"long", strings.Repeat("k", maxLoggedKeyID+1), strings.Repeat("k", maxLoggedKeyID) + "..."},
+		{"multibyte at limit", strings.Repeat("k", maxLoggedKeyID-1) + "é", strings.Repeat("k", maxLoggedKeyID-1) + "..."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateKeyID(tt.kid); got != tt.want {
+				t.Errorf("truncateKeyID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_readKeyFiles(t *testing.T) {
 	type args struct {
 		path string
@@ -257,7 +372,7 @@ func TestVerifier_SetKeys(t *testing.T) {
 				expectedKeyIDs[id] = true
 			}
 			actualKeyIDs := map[string]bool{}
-			for id := range v.Keys {
+			for _, id := range v.KeyIDs() {
 				actualKeyIDs[id] = true
 			}
 			if !reflect.DeepEqual(expectedKeyIDs, actualKeyIDs) {
@@ -266,3 +381,83 @@ func TestVerifier_SetKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestVerifier_KeyAccessors(t *testing.T) {
+	v, err := NewVerifier(validPEMKeys, nil)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	if got, want := v.KeyIDs(), []string{"key1", "key2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("KeyIDs() = %v, want %v", got, want)
+	}
+	if _, found := v.Key("key1"); !found {
+		t.Errorf("Key(key1) not found")
+	}
+	if _, found := v.Key("key99"); found {
+		t.Errorf("Key(key99) found")
+	}
+
+	var empty Verifier
+	if _, found := empty.Key("key1"); found {
+		t.Errorf("Key(key1) found in a Verifier with no keys")
+	}
+	if got := empty.KeyIDs(); len(got) != 0 {
+		t.Errorf("KeyIDs() = %v, want none", got)
+	}
+}
+
+// TestVerifier_ConcurrentSetKeys is meant to be run with -race.
+func TestVerifier_ConcurrentSetKeys(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	_, public := testKeyPEMs(t)
+	token := signTestToken(t, s, testUserClaims)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if err := v.SetKeys(map[string][]byte{"testkey": public}); err != nil {
+					t.Errorf("SetKeys: %v", err)
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := v.VerifyToken(token); err != nil {
+					t.Errorf("VerifyToken: %v", err)
+				}
+				v.JWKKeys()
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkVerifier_KeyFunc(b *testing.B) {
+	v, err := NewVerifier(validPEMKeys, nil)
+	if err != nil {
+		b.Fatalf("NewVerifier: %v", err)
+	}
+	keyfunc := v.KeyFunc()
+	token := &jwt.Token{Header: map[string]interface{}{"kid": "key1"}}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := keyfunc(token); err != nil {
+				b.Fatalf("KeyFunc: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,233 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TokenVersion declares one version of a token type, such as "user/v2".
+//
+// The newest registered version of a type is the current one, and is the
+// form claims take in memory.  Verifiers accept every registered version,
+// upgrading older ones to the current version one step at a time.  Signers
+// emit the current version, upgrading claims of older versions, unless
+// configured with WithTokenVersion, in which case claims are downgraded
+// one step at a time.
+type TokenVersion struct {
+	// Type is the type and version, of the form "<name>/v<version>".
+	Type string
+	// Validate checks claims of this version.  It is required.
+	Validate func(c *SSDClaims) error
+	// Upgrade converts claims of this version into the next version.
+	// It is required for all but the newest version.  Type is set by the caller.
+	Upgrade func(c *SSDClaims) error
+	// Downgrade converts claims of the next version into this version.
+	// It is needed only for Signers configured to emit this version.
+	// Type is set by the caller.
+	Downgrade func(c *SSDClaims) error
+}
+
+type tokenFamily struct {
+	versions map[int]TokenVersion
+	current  int
+}
+
+type tokenTypeRegistry struct {
+	sync.RWMutex
+	families map[string]*tokenFamily
+	custom   map[string]CustomTokenType
+}
+
+// builtinTokenTypes are the types defined by this package.
+var builtinTokenTypes = []string{SSDTokenTypeUser, SSDTokenTypeService, SSDTokenTypeInternal, SSDTokenTypeIntegration}
+
+// registry holds every known token type.  The built-in types are registered
+// when the package is initialized.
+var registry = newTokenTypeRegistry()
+
+func newTokenTypeRegistry() *tokenTypeRegistry {
+	r := &tokenTypeRegistry{
+		families: map[string]*tokenFamily{},
+		custom:   map[string]CustomTokenType{},
+	}
+	for _, t := range builtinTokenTypes {
+		err := r.register(TokenVersion{
+			Type:     t,
+			Validate: claimRulesByType[t].validate,
+		})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// RegisterTokenVersion adds a version of a token type.  It is intended to be
+// called during initialization.
+func RegisterTokenVersion(tv TokenVersion) error {
+	return registry.register(tv)
+}
+
+// ParseTokenType splits a token type such as "user/v1" into its name and version.
+func ParseTokenType(t string) (string, int, error) {
+	name, v, found := strings.Cut(t, "/v")
+	if !found || name == "" {
+		return "", 0, fmt.Errorf("token type %q is not of the form <name>/v<version>", t)
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil || version < 1 || strconv.Itoa(version) != v {
+		return "", 0, fmt.Errorf("token type %q has an invalid version", t)
+	}
+	return name, version, nil
+}
+
+func tokenType(name string, version int) string {
+	return name + "/v" + strconv.Itoa(version)
+}
+
+// tokenTypeName returns the name of a token type without its version,
+// such as "user" for "user/v1".  Types which do not parse are returned
+// unchanged.
+func tokenTypeName(t string) string {
+	name, _, err := ParseTokenType(t)
+	if err != nil {
+		return t
+	}
+	return name
+}
+
+// sameTokenType reports whether a and b are versions of the same type.
+// Verified claims are upgraded to the current version, so checks against
+// a type constant such as SSDTokenTypeUser must ignore the version.
+func sameTokenType(a string, b string) bool {
+	return tokenTypeName(a) == tokenTypeName(b)
+}
+
+// CurrentTokenType returns the current type and version for a token type name,
+// such as "user/v1" for "user".
+func CurrentTokenType(name string) (string, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	f, found := registry.families[name]
+	if !found {
+		return "", false
+	}
+	return tokenType(name, f.current), true
+}
+
+func (r *tokenTypeRegistry) register(tv TokenVersion) error {
+	name, version, err := ParseTokenType(tv.Type)
+	if err != nil {
+		return err
+	}
+	if tv.Validate == nil {
+		return fmt.Errorf("token type %s: Validate is required", tv.Type)
+	}
+	r.Lock()
+	defer r.Unlock()
+	f, found := r.families[name]
+	if !found {
+		f = &tokenFamily{versions: map[int]TokenVersion{}}
+		r.families[name] = f
+	}
+	if _, found := f.versions[version]; found {
+		return fmt.Errorf("token type %s is already registered", tv.Type)
+	}
+	f.versions[version] = tv
+	if version > f.current {
+		f.current = version
+	}
+	return nil
+}
+
+func (r *tokenTypeRegistry) version(t string) (TokenVersion, bool) {
+	name, version, err := ParseTokenType(t)
+	if err != nil {
+		return TokenVersion{}, false
+	}
+	r.RLock()
+	defer r.RUnlock()
+	f, found := r.families[name]
+	if !found {
+		return TokenVersion{}, false
+	}
+	tv, found := f.versions[version]
+	return tv, found
+}
+
+// UpgradeSSDClaims validates claims of any registered version, and converts
+// them in place to the current version of their type.
+func UpgradeSSDClaims(c *SSDClaims) error {
+	if err := ValidateSSDClaims(c); err != nil {
+		return err
+	}
+	name, version, _ := ParseTokenType(c.Type)
+	current, _ := CurrentTokenType(name)
+	if c.Type == current {
+		return nil
+	}
+	// Upgrade functions may modify the slices in place, so they are given
+	// copies rather than slices shared with the caller's claims.
+	c.Groups = slices.Clone(c.Groups)
+	c.Authorizations = slices.Clone(c.Authorizations)
+	for c.Type != current {
+		tv, _ := registry.version(c.Type)
+		if tv.Upgrade == nil {
+			return fmt.Errorf("token type %s cannot be upgraded to %s", c.Type, current)
+		}
+		if err := tv.Upgrade(c); err != nil {
+			return fmt.Errorf("upgrading %s: %v", c.Type, err)
+		}
+		version++
+		c.Type = tokenType(name, version)
+		if _, found := registry.version(c.Type); !found {
+			return fmt.Errorf("token type %s is not registered", c.Type)
+		}
+	}
+	return ValidateSSDClaims(c)
+}
+
+// DowngradeSSDClaims returns a copy of claims of the current version
+// converted to an older version of the same type.
+func DowngradeSSDClaims(c SSDClaims, version int) (SSDClaims, error) {
+	name, current, err := ParseTokenType(c.Type)
+	if err != nil {
+		return SSDClaims{}, err
+	}
+	if version > current {
+		return SSDClaims{}, fmt.Errorf("cannot downgrade %s to newer version %d", c.Type, version)
+	}
+	c.Groups = slices.Clone(c.Groups)
+	c.Authorizations = slices.Clone(c.Authorizations)
+	for v := current - 1; v >= version; v-- {
+		tv, found := registry.version(tokenType(name, v))
+		if !found || tv.Downgrade == nil {
+			return SSDClaims{}, fmt.Errorf("token type %s cannot be downgraded to v%d", c.Type, v)
+		}
+		if err := tv.Downgrade(&c); err != nil {
+			return SSDClaims{}, fmt.Errorf("downgrading %s: %v", c.Type, err)
+		}
+		c.Type = tokenType(name, v)
+	}
+	if err := ValidateSSDClaims(&c); err != nil {
+		return SSDClaims{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,357 @@
+// Copyright 2024 OpsMx, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssdjwtauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// The widget type is used only by these tests.  Version 2 added the
+// instance field, which version 1 did not have.
+func init() {
+	err := RegisterTokenVersion(TokenVersion{
+		Type: "widget/v1",
+		Validate: func(c *SSDClaims) error {
+			if c.Service == "" || c.Instance != "" {
+				return fmt.Errorf("widget/v1 requires service and no instance")
+			}
+			return nil
+		},
+		Upgrade: func(c *SSDClaims) error {
+			c.Instance = "default"
+			return nil
+		},
+		Downgrade: func(c *SSDClaims) error {
+			c.Instance = ""
+			return nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = RegisterTokenVersion(TokenVersion{
+		Type: "widget/v2",
+		Validate: func(c *SSDClaims) error {
+			if c.Service == "" || c.Instance == "" {
+				return fmt.Errorf("widget/v2 requires service and instance")
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestParseTokenType(t *testing.T) {
+	tests := []struct {
+		t           string
+		wantName    string
+		wantVersion int
+		wantErr     bool
+	}{
+		{"user/v1", "user", 1, false},
+		{"internal-account/v12", "internal-account", 12, false},
+		{"user", "", 0, true},
+		{"/v1", "", 0, true},
+		{"user/v0", "", 0, true},
+		{"user/v01", "", 0, true},
+		{"user/vx", "", 0, true},
+		{"user/v-1", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.t, func(t *testing.T) {
+			name, version, err := ParseTokenType(tt.t)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTokenType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || version != tt.wantVersion {
+				t.Errorf("ParseTokenType() = %s, %d, want %s, %d", name, version, tt.wantName, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestRegisterTokenVersion_errors(t *testing.T) {
+	validate := func(c *SSDClaims) error { return nil }
+	tests := []struct {
+		name string
+		tv   TokenVersion
+	}{
+		{"bad type", TokenVersion{Type: "gadget", Validate: validate}},
+		{"no validate", TokenVersion{Type: "gadget/v1"}},
+		{"duplicate", TokenVersion{Type: SSDTokenTypeUser, Validate: validate}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := RegisterTokenVersion(tt.tv); err == nil {
+				t.Errorf("expected RegisterTokenVersion to fail")
+			}
+		})
+	}
+}
+
+func TestUpgradeSSDClaims(t *testing.T) {
+	if current, _ := CurrentTokenType("widget"); current != "widget/v2" {
+		t.Fatalf("expected current widget type to be widget/v2, got %s", current)
+	}
+	tests := []struct {
+		name         string
+		claims       SSDClaims
+		wantInstance string
+		wantErr      bool
+	}{
+		{"current", SSDClaims{Type: "widget/v2", Service: "w", Instance: "i"}, "i", false},
+		{"upgraded", SSDClaims{Type: "widget/v1", Service: "w"}, "default", false},
+		{"invalid old version", SSDClaims{Type: "widget/v1"}, "", true},
+		{"unknown version", SSDClaims{Type: "widget/v3", Service: "w", Instance: "i"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.claims
+			err := UpgradeSSDClaims(&c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpgradeSSDClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.Type != "widget/v2" || c.Instance != tt.wantInstance {
+				t.Errorf("UpgradeSSDClaims() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestDowngradeSSDClaims(t *testing.T) {
+	c := SSDClaims{Type: "widget/v2", Service: "w", Instance: "i"}
+	got, err := DowngradeSSDClaims(c, 1)
+	if err != nil {
+		t.Fatalf("DowngradeSSDClaims: %v", err)
+	}
+	if got.Type != "widget/v1" || got.Instance != "" {
+		t.Errorf("DowngradeSSDClaims() = %+v", got)
+	}
+	if c.Instance != "i" {
+		t.Errorf("DowngradeSSDClaims modified its input")
+	}
+	if _, err := DowngradeSSDClaims(c, 3); err == nil {
+		t.Errorf("expected downgrading to a newer version to fail")
+	}
+	if _, err := DowngradeSSDClaims(testUserClaims, 0); err == nil {
+		t.Errorf("expected downgrading to an unregistered version to fail")
+	}
+}
+
+func TestSigner_WithTokenVersion(t *testing.T) {
+	private, _ := testKeyPEMs(t)
+	_, v := newTestSignerVerifier(t)
+	s, err := NewSigner("testkey", private, WithTokenVersion("widget", 1))
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	now := time.Now()
+	token, err := s.SignToken(s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{Type: "widget/v2", Service: "w", Instance: "i"}))
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+
+	// the token on the wire is version 1
+	unverified := &SsdJwtClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, unverified); err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if unverified.SSDCLaims.Type != "widget/v1" || unverified.SSDCLaims.Instance != "" {
+		t.Errorf("expected a widget/v1 token, got %+v", unverified.SSDCLaims)
+	}
+
+	// and the verifier upgrades it to the current version
+	claims, err := v.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("VerifyToken: %v", err)
+	}
+	if claims.SSDCLaims.Type != "widget/v2" || claims.SSDCLaims.Instance != "default" {
+		t.Errorf("expected upgraded widget/v2 claims, got %+v", claims.SSDCLaims)
+	}
+}
+
+func TestSigner_upgradesOlderVersions(t *testing.T) {
+	s, v := newTestSignerVerifier(t)
+	now := time.Now()
+	claims := s.MakeClaims(now, now.Add(time.Hour), "id", SSDClaims{Type: "widget/v1", Service: "w"})
+	token, err := s.SignToken(claims)
+	if err != nil {
+		t.Fatalf("SignToken: %v", err)
+	}
+	if claims.SSDCLaims.Type != "widget/v1" || claims.SSDCLaims.Instance != "" {
+		t.Errorf("SignToken modified its input: %+v", claims.SSDCLaims)
+	}
+
+	// without WithTokenVersion, the token on the wire is the current version
+	unverified := &SsdJwtClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, unverified); err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	if unverified.SSDCLaims.Type != "widget/v2" || unverified.SSDCLaims.Instance != "default" {
+		t.Errorf("expected a widget/v2 token, got %+v", unverified.SSDCLaims)
+	}
+	if _, err := v.VerifyToken(token); err != nil {
+		t.Errorf("VerifyToken: %v", err)
+	}
+}
+
+func TestUpgradeSSDClaims_copiesSlices(t *testing.T) {
+	useTestRegistry(t, func(r *tokenTypeRegistry) {
+		r.families["user"].versions[1] = TokenVersion{
+			Type:     SSDTokenTypeUser,
+			Validate: claimRulesByType[SSDTokenTypeUser].validate,
+			Upgrade: func(c *SSDClaims) error {
+				for i := range c.Groups {
+					c.Groups[i] = "v2-" + c.Groups[i]
+				}
+				return nil
+			},
+		}
+		if err := r.register(TokenVersion{Type: "user/v2", Validate: claimRulesByType[SSDTokenTypeUser].validate}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	old := SSDClaims{Type: SSDTokenTypeUser, UserID: "alice", OrgID: "org1", Groups: []string{"dev"}}
+	c := old
+	if err := UpgradeSSDClaims(&c); err != nil {
+		t.Fatalf("UpgradeSSDClaims: %v", err)
+	}
+	if c.Groups[0] != "v2-dev" {
+		t.Errorf("expected upgraded groups, got %v", c.Groups)
+	}
+	if old.Groups[0] != "dev" {
+		t.Errorf("upgrading modified the groups of the original claims: %v", old.Groups)
+	}
+}
+
+// useTestRegistry replaces the token type registry for the rest of the
+// test with a new one holding the built-in types, after applying setup.
+func useTestRegistry(t *testing.T, setup func(r *tokenTypeRegistry)) {
+	t.Helper()
+	saved := registry
+	r := newTokenTypeRegistry()
+	setup(r)
+	registry = r
+	t.Cleanup(func() { registry = saved })
+}
+
+// registerBuiltinV2 adds version 2 of a built-in type, with the same rules
+// as version 1 and an upgrade which changes nothing.
+func registerBuiltinV2(t *testing.T, r *tokenTypeRegistry, v1 string) {
+	t.Helper()
+	name := tokenTypeName(v1)
+	tv := r.families[name].versions[1]
+	tv.Upgrade = func(c *SSDClaims) error { return nil }
+	r.families[name].versions[1] = tv
+	if err := r.register(TokenVersion{Type: tokenType(name, 2), Validate: tv.Validate}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuiltinTypes_version2(t *testing.T) {
+	useTestRegistry(t, func(r *tokenTypeRegistry) {
+		for _, v1 := range []string{SSDTokenTypeUser, SSDTokenTypeService, SSDTokenTypeInternal, SSDTokenTypeIntegration} {
+			registerBuiltinV2(t, r, v1)
+		}
+	})
+	s, v := newTestSignerVerifier(t)
+	admin := testUserClaims
+	admin.IsAdmin = true
+
+	tests := []struct {
+		name   string
+		ssd    SSDClaims
+		v1     string
+		tenant string
+		from   func(*SsdJwtClaims) error
+	}{
+		{"user", admin, SSDTokenTypeUser, "org1", func(c *SsdJwtClaims) error { _, err := SSDUserClaimsFromClaims(c); return err }},
+		{"service", testServiceClaims, SSDTokenTypeService, "org1", func(c *SsdJwtClaims) error { _, err := SSDServiceClaimsFromClaims(c); return err }},
+		{"internal", testInternalClaims, SSDTokenTypeInternal, "org9", func(c *SsdJwtClaims) error { _, err := SSDInternalClaimsFromClaims(c); return err }},
+		{"integration", testIntegrationClaims, SSDTokenTypeIntegration, "org1", func(c *SsdJwtClaims) error { _, err := SSDIntegrationClaimsFromClaims(c); return err }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := v.VerifyToken(signTestToken(t, s, tt.ssd))
+			if err != nil {
+				t.Fatalf("VerifyToken: %v", err)
+			}
+			if want := tokenType(tokenTypeName(tt.v1), 2); claims.SSDCLaims.Type != want {
+				t.Fatalf("expected verified claims of type %s, got %s", want, claims.SSDCLaims.Type)
+			}
+			if err := tt.from(claims); err != nil {
+				t.Errorf("%s claims from version 2: %v", tt.name, err)
+			}
+			identity, err := IdentityFromClaims(claims)
+			if err != nil {
+				t.Errorf("IdentityFromClaims: %v", err)
+			} else if identity.Type() != claims.SSDCLaims.Type {
+				t.Errorf("Identity.Type() = %s, want %s", identity.Type(), claims.SSDCLaims.Type)
+			}
+			if err := TokenTypeCheck(tt.v1)(nil, claims); err != nil {
+				t.Errorf("TokenTypeCheck(%s): %v", tt.v1, err)
+			}
+			opts := TenancyOptions{Level: TenantOrg, AllowAdmin: true, AllowInternal: true}
+			if err := CheckTenant(claims, tt.tenant, opts); err != nil {
+				t.Errorf("CheckTenant: %v", err)
+			}
+			if want := claims.SSDCLaims.IsAdmin || tt.v1 == SSDTokenTypeInternal; isPrivileged(claims) != want {
+				t.Errorf("isPrivileged() = %v, want %v", !want, want)
+			}
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r = r.WithContext(contextWithToken(r.Context(), claims, "token"))
+			w := httptest.NewRecorder()
+			RequireTokenType(tt.v1)(http.NotFoundHandler()).ServeHTTP(w, r)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("RequireTokenType(%s) returned status %d", tt.v1, w.Code)
+			}
+
+			_, public := testKeyPEMs(t)
+			bound, err := NewVerifier(map[string][]byte{"testkey": public}, nil,
+				WithDPoP(DPoPOptions{RequiredTypes: []string{tt.v1}}))
+			if err != nil {
+				t.Fatalf("NewVerifier: %v", err)
+			}
+			if _, err := bound.VerifyToken(signTestToken(t, s, tt.ssd)); err == nil {
+				t.Errorf("expected a bearer token to be rejected when %s must be DPoP bound", tt.v1)
+			}
+
+			once, err := NewVerifier(map[string][]byte{"testkey": public}, nil,
+				WithReplayCache(&MemoryReplayCache{}, tt.v1))
+			if err != nil {
+				t.Fatalf("NewVerifier: %v", err)
+			}
+			token := signTestToken(t, s, tt.ssd)
+			if _, err := once.VerifyToken(token); err != nil {
+				t.Errorf("VerifyToken of a single-use token: %v", err)
+			}
+			if _, err := once.VerifyToken(token); err == nil {
+				t.Errorf("expected a %s token to be accepted only once", tt.v1)
+			}
+		})
+	}
+}